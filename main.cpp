@@ -11,6 +11,7 @@
 #include <cstring>
 #include <cstdlib>
 #include <csignal>
+#include <cctype>
 #include <unistd.h>
 #include <fcntl.h>
 #include <sched.h>
@@ -37,12 +38,40 @@
 #include <sys/ioctl.h>
 #include <sys/socket.h>
 #include <sys/un.h>
+#include <poll.h>
+#include <linux/nsfs.h>
 
 #include "json.hpp"
 
 // A convenient alias for nlohmann::json
 using json = nlohmann::json;
 
+// New mount API, for idmapped mounts. Older libc headers lack these.
+#ifndef SYS_open_tree
+#define SYS_open_tree 428
+#endif
+#ifndef SYS_move_mount
+#define SYS_move_mount 429
+#endif
+#ifndef SYS_mount_setattr
+#define SYS_mount_setattr 442
+#endif
+#ifndef OPEN_TREE_CLONE
+#define OPEN_TREE_CLONE 1
+#endif
+#ifndef OPEN_TREE_CLOEXEC
+#define OPEN_TREE_CLOEXEC O_CLOEXEC
+#endif
+#ifndef MOVE_MOUNT_F_EMPTY_PATH
+#define MOVE_MOUNT_F_EMPTY_PATH 0x00000004
+#endif
+#ifndef MOUNT_ATTR_IDMAP
+#define MOUNT_ATTR_IDMAP 0x00100000
+#endif
+#ifndef AT_RECURSIVE
+#define AT_RECURSIVE 0x8000
+#endif
+
 extern char** environ;
 
 constexpr int STACK_SIZE = 1024 * 1024; // 1MB
@@ -56,6 +85,8 @@ struct GlobalOptions {
     std::string log_path;
     std::string log_format = "text";
     std::string root_path;
+    bool strict = false;
+    bool private_cgroupns = false;
 };
 
 static GlobalOptions g_global_options;
@@ -69,7 +100,9 @@ enum GlobalOptionValue {
     OPT_ROOT,
     OPT_VERSION,
     OPT_HELP,
-    OPT_SYSTEMD_CGROUP
+    OPT_SYSTEMD_CGROUP,
+    OPT_STRICT,
+    OPT_PRIVATE_CGROUPNS
 };
 
 std::string ensure_trailing_slash(const std::string& path) {
@@ -98,6 +131,25 @@ std::string default_state_root() {
     return fallback_state_root();
 }
 
+static std::streambuf* g_original_cerr_buf = nullptr;
+
+// std::cerr is flushed after static destructors have run, so it must not be
+// left pointing at the log file (or json line) buffer at exit.
+void restore_cerr_buffer() {
+    if (g_original_cerr_buf != nullptr) {
+        std::cerr.flush();
+        std::cerr.rdbuf(g_original_cerr_buf);
+    }
+}
+
+void redirect_cerr(std::streambuf* buffer) {
+    if (g_original_cerr_buf == nullptr) {
+        g_original_cerr_buf = std::cerr.rdbuf();
+        std::atexit(restore_cerr_buffer);
+    }
+    std::cerr.rdbuf(buffer);
+}
+
 bool configure_log_destination(const std::string& path) {
     std::unique_ptr<std::ofstream> stream(new std::ofstream(path, std::ios::app));
     if (!stream || !(*stream)) {
@@ -105,13 +157,122 @@ bool configure_log_destination(const std::string& path) {
         return false;
     }
     g_log_stream = std::move(stream);
-    std::cerr.rdbuf(g_log_stream->rdbuf());
+    redirect_cerr(g_log_stream->rdbuf());
     return true;
 }
 
+std::string iso8601_now();
+
+// Current command name and container id, attached to json log records as "op" and "id".
+static std::string g_log_operation;
+static std::string g_log_container_id;
+
+json log_record(const std::string& level, const std::string& message) {
+    json entry = {
+            {"time", iso8601_now()},
+            {"level", level},
+            {"msg", message}
+    };
+    if (!g_log_operation.empty()) {
+        entry["op"] = g_log_operation;
+    }
+    if (!g_log_container_id.empty()) {
+        entry["id"] = g_log_container_id;
+    }
+    return entry;
+}
+
+// With --log-format json, std::cerr is routed through this buffer so that every
+// diagnostic line becomes a json record. The level comes from the "Error:" /
+// "Warning:" prefix the messages already carry; anything else is an error.
+class JsonLogLineBuf : public std::streambuf {
+public:
+    explicit JsonLogLineBuf(std::streambuf* target) : target_(target) {}
+
+    ~JsonLogLineBuf() override {
+        if (!line_.empty()) {
+            emit();
+        }
+    }
+
+    std::streambuf* target() const { return target_; }
+
+    void write_record(const json& entry) {
+        const std::string line = entry.dump() + "\n";
+        target_->sputn(line.data(), static_cast<std::streamsize>(line.size()));
+        target_->pubsync();
+    }
+
+protected:
+    int overflow(int ch) override {
+        if (ch == traits_type::eof()) {
+            return traits_type::not_eof(ch);
+        }
+        if (ch == '\n') {
+            emit();
+        } else {
+            line_.push_back(static_cast<char>(ch));
+        }
+        return ch;
+    }
+
+    // std::cerr is unit-buffered; partial lines are held until the newline.
+    int sync() override { return 0; }
+
+private:
+    void emit() {
+        std::string level = "error";
+        std::string message = line_;
+        line_.clear();
+        const std::pair<const char*, const char*> prefixes[] = {{"Error: ", "error"}, {"Warning: ", "warn"}};
+        for (const auto& prefix : prefixes) {
+            if (message.rfind(prefix.first, 0) == 0) {
+                message.erase(0, std::strlen(prefix.first));
+                level = prefix.second;
+                break;
+            }
+        }
+        if (!message.empty()) {
+            write_record(log_record(level, message));
+        }
+    }
+
+    std::streambuf* target_;
+    std::string line_;
+};
+
+static JsonLogLineBuf* g_json_log_buf = nullptr;
+
+void enable_json_log_lines() {
+    static JsonLogLineBuf buffer(std::cerr.rdbuf());
+    redirect_cerr(&buffer);
+    g_json_log_buf = &buffer;
+}
+
+void log_message(const std::string& level, const std::string& message) {
+    if (g_global_options.log_format == "json") {
+        json entry = log_record(level, message);
+        if (g_json_log_buf != nullptr && std::cerr.rdbuf() == g_json_log_buf) {
+            g_json_log_buf->write_record(entry);
+        } else {
+            std::cerr << entry.dump() << std::endl;
+        }
+        return;
+    }
+    std::cerr << "[" << level << "] " << message << std::endl;
+}
+
+// perror() replacement that goes through std::cerr, so --log and
+// --log-format apply to it.
+void log_errno(const std::string& what) {
+    int saved_errno = errno;
+    std::cerr << what << ": " << std::strerror(saved_errno) << std::endl;
+    errno = saved_errno;
+}
+
 void log_debug(const std::string& message) {
     if (g_global_options.debug) {
-        std::cerr << "[debug] " << message << std::endl;
+        log_message("debug", message);
     }
 }
 
@@ -122,6 +283,8 @@ struct ProcessConfig {
     std::vector<std::string> args;
     std::vector<std::string> env;
     std::string cwd = "/";
+    bool has_oom_score_adj = false;
+    int oom_score_adj = 0;
 };
 
 struct RootConfig {
@@ -144,8 +307,40 @@ struct LinuxIDMapping {
 struct LinuxResourcesConfig {
     long long memory_limit = 0; // memory.limit_in_bytes
     long long cpu_shares = 0;   // cpu.shares
+    // v2の保護/スロットリング値。0は未指定、-1は"max"
+    long long memory_reservation = 0; // memory.low (v1: memory.soft_limit_in_bytes)
+    long long memory_min = 0;         // memory.min
+    long long memory_high = 0;        // memory.high
 };
 
+// memory.high などの値。"max" は -1 として扱う
+bool parse_memory_value(const std::string& text, long long& value) {
+    if (text == "max") {
+        value = -1;
+        return true;
+    }
+    try {
+        size_t consumed = 0;
+        long long parsed = std::stoll(text, &consumed);
+        if (consumed != text.size() || parsed < 0) {
+            return false;
+        }
+        value = parsed;
+        return true;
+    } catch (const std::exception&) {
+        return false;
+    }
+}
+
+std::string memory_value_string(long long value) {
+    return value < 0 ? "max" : std::to_string(value);
+}
+
+// cgroup v1 memory files only take "-1" for unlimited, not "max".
+std::string memory_v1_value_string(long long value) {
+    return value < 0 ? "-1" : std::to_string(value);
+}
+
 struct MountConfig {
     std::string destination;
     std::string type;
@@ -212,6 +407,10 @@ void from_json(const json& j, ProcessConfig& p) {
     if (j.contains("env")) {
         j.at("env").get_to(p.env);
     }
+    if (j.contains("oomScoreAdj")) {
+        j.at("oomScoreAdj").get_to(p.oom_score_adj);
+        p.has_oom_score_adj = true;
+    }
 }
 
 void from_json(const json& j, RootConfig& r) {
@@ -242,6 +441,25 @@ void from_json(const json& j, LinuxResourcesConfig& res) {
     if (j.contains("memory") && j["memory"].contains("limit")) {
         j["memory"].at("limit").get_to(res.memory_limit);
     }
+    if (j.contains("memory") && j["memory"].contains("reservation")) {
+        j["memory"].at("reservation").get_to(res.memory_reservation);
+    }
+    if (j.contains("unified")) {
+        const std::pair<const char*, long long*> unified_memory[] = {
+                {"memory.min", &res.memory_min},
+                {"memory.low", &res.memory_reservation},
+                {"memory.high", &res.memory_high}
+        };
+        for (const auto& entry : unified_memory) {
+            if (!j["unified"].contains(entry.first)) {
+                continue;
+            }
+            const std::string text = j["unified"].at(entry.first).get<std::string>();
+            if (!parse_memory_value(text, *entry.second)) {
+                throw std::runtime_error(std::string("Invalid value for linux.resources.unified.") + entry.first + ": " + text);
+            }
+        }
+    }
     if (j.contains("cpu") && j["cpu"].contains("shares")) {
         j["cpu"].at("shares").get_to(res.cpu_shares);
     }
@@ -345,7 +563,7 @@ void from_json(const json& j, OCIConfig& c) {
 }
 
 // RW とパース用関数
-OCIConfig load_config(const std::string& bundle_path) {
+json load_config_json(const std::string& bundle_path) {
     std::string config_path = bundle_path + "/config.json";
     std::ifstream ifs(config_path);
     if (!ifs) {
@@ -353,7 +571,137 @@ OCIConfig load_config(const std::string& bundle_path) {
     }
     json j;
     ifs >> j;
-    return j.get<OCIConfig>();
+    return j;
+}
+
+OCIConfig load_config(const std::string& bundle_path) {
+    return load_config_json(bundle_path).get<OCIConfig>();
+}
+
+// Spec fields honored by the runtime. `true` accepts any value below that key,
+// an object lists the accepted child keys (applied to each element of arrays).
+const json& supported_spec_fields() {
+    static const json fields = {
+            {"ociVersion", true},
+            {"root", {{"path", true}, {"readonly", true}}},
+            {"process", {{"terminal", true}, {"args", true}, {"env", true}, {"cwd", true}, {"oomScoreAdj", true}}},
+            {"hostname", true},
+            {"mounts", {{"destination", true}, {"type", true}, {"source", true}, {"options", true}}},
+            {"annotations", true},
+            {"hooks", {
+                    {"createRuntime", {{"path", true}, {"args", true}, {"env", true}, {"timeout", true}}},
+                    {"createContainer", {{"path", true}, {"args", true}, {"env", true}, {"timeout", true}}},
+                    {"startContainer", {{"path", true}, {"args", true}, {"env", true}, {"timeout", true}}},
+                    {"prestart", {{"path", true}, {"args", true}, {"env", true}, {"timeout", true}}},
+                    {"poststart", {{"path", true}, {"args", true}, {"env", true}, {"timeout", true}}},
+                    {"poststop", {{"path", true}, {"args", true}, {"env", true}, {"timeout", true}}}
+            }},
+            {"linux", {
+                    {"namespaces", {{"type", true}, {"path", true}}},
+                    {"resources", {
+                            {"memory", {{"limit", true}, {"reservation", true}}},
+                            {"cpu", {{"shares", true}}},
+                            {"unified", {{"memory.min", true}, {"memory.low", true}, {"memory.high", true}}}
+                    }},
+                    {"uidMappings", {{"hostID", true}, {"containerID", true}, {"size", true}}},
+                    {"gidMappings", {{"hostID", true}, {"containerID", true}, {"size", true}}},
+                    {"maskedPaths", true},
+                    {"readonlyPaths", true},
+                    {"rootfsPropagation", true},
+                    {"cgroupsPath", true}
+            }}
+    };
+    return fields;
+}
+
+void collect_unsupported_fields(const json& value,
+                                const json& allowed,
+                                const std::string& prefix,
+                                std::set<std::string>& out) {
+    if (!allowed.is_object()) {
+        return;
+    }
+    if (value.is_array()) {
+        for (const auto& element : value) {
+            collect_unsupported_fields(element, allowed, prefix + "[]", out);
+        }
+        return;
+    }
+    if (!value.is_object()) {
+        return;
+    }
+    for (auto it = value.begin(); it != value.end(); ++it) {
+        if (it.value().is_null()) {
+            continue;
+        }
+        const std::string path = prefix.empty() ? it.key() : prefix + "." + it.key();
+        auto allowed_it = allowed.find(it.key());
+        if (allowed_it == allowed.end()) {
+            out.insert(path);
+            continue;
+        }
+        collect_unsupported_fields(it.value(), *allowed_it, path, out);
+    }
+}
+
+// Lists spec fields that would be accepted but silently ignored.
+std::vector<std::string> unsupported_spec_fields(const json& spec) {
+    std::set<std::string> fields;
+    collect_unsupported_fields(spec, supported_spec_fields(), "", fields);
+    return std::vector<std::string>(fields.begin(), fields.end());
+}
+
+// Fields the runtime accepts but only enforces on some hosts: the cgroup v2
+// memory knobs that apply_memory_resources skips on v1.
+std::vector<std::string> host_unenforced_spec_fields(const json& spec, bool cgroup_v2) {
+    std::vector<std::string> fields;
+    if (cgroup_v2 || !spec.contains("linux") || !spec["linux"].contains("resources") ||
+        !spec["linux"]["resources"].contains("unified")) {
+        return fields;
+    }
+    const json& unified = spec["linux"]["resources"]["unified"];
+    for (const char* key : {"memory.min", "memory.high"}) {
+        if (unified.contains(key)) {
+            fields.push_back(std::string("linux.resources.unified.") + key);
+        }
+    }
+    return fields;
+}
+
+// OCI runtime features document (runtime-spec features.md), printed by the
+// `features` command so callers can probe this runtime before routing specs to it.
+json runtime_features() {
+    json hooks = json::array();
+    for (auto it = supported_spec_fields()["hooks"].begin(); it != supported_spec_fields()["hooks"].end(); ++it) {
+        hooks.push_back(it.key());
+    }
+    return json{
+            {"ociVersionMin", "1.0.0"},
+            {"ociVersionMax", "1.1.0"},
+            {"hooks", hooks},
+            {"mountOptions", {
+                    "bind", "dirsync", "idmap", "nodev", "noexec", "norelatime", "nostrictatime", "nosuid",
+                    "private", "rbind", "recursive", "relatime", "remount", "ridmap", "ro", "rprivate",
+                    "rshared", "rslave", "runbindable", "rw", "shared", "slave", "strictatime", "sync",
+                    "unbindable"
+            }},
+            {"linux", {
+                    {"namespaces", {"cgroup", "ipc", "mount", "network", "pid", "user", "uts"}},
+                    {"cgroup", {
+                            {"v1", true},
+                            {"v2", true},
+                            {"systemd", false},
+                            {"systemdUser", false},
+                            {"rdma", false}
+                    }},
+                    {"seccomp", {{"enabled", false}}},
+                    {"apparmor", {{"enabled", false}}},
+                    {"selinux", {{"enabled", false}}},
+                    {"intelRdt", {{"enabled", false}}},
+                    {"mountExtensions", {{"idmap", {{"enabled", true}}}}}
+            }},
+            {"annotations", {{"runway.version", RUNTIME_VERSION}}}
+    };
 }
 
 // FIFO用のヘルパー関数 以下 Claude生成
@@ -389,6 +737,12 @@ struct ContainerArgs {
     std::vector<std::pair<int, int>> join_namespaces;
     bool terminal = false;
     int console_slave_fd = -1;
+    bool new_user_namespace = false;
+    bool new_cgroup_namespace = false;
+    // Receives idmapped mount fds from the parent: rootfs first, then each
+    // idmap/ridmap mount in order.
+    int idmap_socket_fd = -1;
+    bool idmap_rootfs = false;
 };
 
 struct CreateOptions {
@@ -418,6 +772,13 @@ struct EventsOptions {
     int interval_ms = 1000;
 };
 
+struct UpdateOptions {
+    std::string id;
+    bool has_oom_score_adj = false;
+    int oom_score_adj = 0;
+    LinuxResourcesConfig resources;
+};
+
 // Struct to represent the container's state
 struct ContainerState {
     std::string version;
@@ -480,7 +841,7 @@ bool save_state(const ContainerState& state) {
     std::string container_path = state_base_path() + state.id;
     std::string state_file_path = container_path + "/state.json";
     if (mkdir(container_path.c_str(), 0755) != 0 && errno != EEXIST) {
-        perror("Failed to create state directory");
+        log_errno("Failed to create state directory");
         return false;
     }
     std::ofstream ofs(state_file_path);
@@ -538,34 +899,427 @@ bool run_hook_sequence(const std::vector<HookConfig>& hooks,
                        const std::string& hook_type,
                        bool enforce_once = true);
 
+// Normalizes linux.cgroupsPath (or the saved annotation) into a path relative to CGROUP_BASE_PATH.
+std::string cgroup_relative_path(const std::string& id, const std::string& hint) {
+    std::string relative_path = hint;
+    if (!relative_path.empty() && relative_path.front() == '/') {
+        relative_path.erase(0, 1);
+    }
+    while (!relative_path.empty() && relative_path.back() == '/') {
+        relative_path.pop_back();
+    }
+    if (relative_path.empty()) {
+        relative_path = "my_runtime/" + id;
+    }
+    return relative_path;
+}
+
+bool is_cgroup_v2() {
+    const std::string controllers_file = CGROUP_BASE_PATH + "cgroup.controllers";
+    return access(controllers_file.c_str(), F_OK) == 0;
+}
+
+std::string container_cgroup_hint(const ContainerState& state) {
+    auto it = state.annotations.find("runway.cgroupPath");
+    if (it != state.annotations.end()) {
+        return it->second;
+    }
+    return "";
+}
+
+// cgroup.procs は1行に1PID
+std::vector<pid_t> read_cgroup_procs(const std::string& procs_path) {
+    std::vector<pid_t> pids;
+    std::ifstream ifs(procs_path);
+    pid_t pid = 0;
+    while (ifs >> pid) {
+        if (pid > 0) {
+            pids.push_back(pid);
+        }
+    }
+    return pids;
+}
+
+// Returns the cgroup.procs files that may list the container's processes.
+std::vector<std::string> container_cgroup_procs_files(const ContainerState& state) {
+    std::string relative_path = cgroup_relative_path(state.id, container_cgroup_hint(state));
+    if (is_cgroup_v2()) {
+        return {CGROUP_BASE_PATH + relative_path + "/cgroup.procs"};
+    }
+    return {
+            CGROUP_BASE_PATH + "memory/" + relative_path + "/cgroup.procs",
+            CGROUP_BASE_PATH + "cpu/" + relative_path + "/cgroup.procs"
+    };
+}
+
+bool join_container_cgroup(const ContainerState& state, pid_t pid) {
+    for (const auto& procs_file : container_cgroup_procs_files(state)) {
+        if (access(procs_file.c_str(), F_OK) != 0) {
+            continue;
+        }
+        try {
+            write_cgroup_file(procs_file, std::to_string(pid));
+        } catch (const std::exception& e) {
+            std::cerr << "Failed to join container cgroup: " << e.what() << std::endl;
+            return false;
+        }
+    }
+    return true;
+}
+
+// Writes cgroup.freeze in a v2 cgroup directory. False when the file is missing
+// (v1 host or pre-5.2 kernel), in which case callers fall back to signals.
+bool write_cgroup_freeze(const std::string& cgroup_dir, bool frozen) {
+    std::string freeze_file = cgroup_dir + "/cgroup.freeze";
+    if (access(freeze_file.c_str(), W_OK) != 0) {
+        return false;
+    }
+    try {
+        write_cgroup_file(freeze_file, frozen ? "1" : "0");
+    } catch (const std::exception& e) {
+        log_debug(std::string("cgroup.freeze write failed: ") + e.what());
+        return false;
+    }
+    return true;
+}
+
+const int FREEZER_TIMEOUT_MS = 5000;
+const int KILL_EXIT_TIMEOUT_MS = 5000;
+
+// Reads the "frozen" key of a v2 cgroup.events file.
+bool parse_cgroup_events_frozen(std::istream& events, bool& frozen) {
+    std::string key;
+    std::string value;
+    while (events >> key >> value) {
+        if (key == "frozen") {
+            frozen = (value == "1");
+            return true;
+        }
+    }
+    return false;
+}
+
+// The kernel may take a while to freeze every task; wait until cgroup.events
+// reports the requested state.
+bool wait_for_cgroup_frozen(const std::string& cgroup_dir, bool frozen, int timeout_ms) {
+    auto deadline = std::chrono::steady_clock::now() + std::chrono::milliseconds(timeout_ms);
+    while (true) {
+        std::ifstream events(cgroup_dir + "/cgroup.events");
+        bool current = !frozen;
+        if (events && parse_cgroup_events_frozen(events, current) && current == frozen) {
+            return true;
+        }
+        if (std::chrono::steady_clock::now() >= deadline) {
+            return false;
+        }
+        std::this_thread::sleep_for(std::chrono::milliseconds(10));
+    }
+}
+
+std::string container_cgroup_v2_dir(const ContainerState& state) {
+    return CGROUP_BASE_PATH + cgroup_relative_path(state.id, container_cgroup_hint(state));
+}
+
+// Reads one entry from a flat keyed cgroup file such as memory.events.
+bool read_cgroup_keyed_value(const std::string& path, const std::string& key, unsigned long long& value) {
+    std::ifstream ifs(path);
+    std::string current_key;
+    unsigned long long current_value = 0;
+    while (ifs >> current_key >> current_value) {
+        if (current_key == key) {
+            value = current_value;
+            return true;
+        }
+    }
+    return false;
+}
+
+// Number of OOM kills in the container cgroup (memory.events on v2,
+// memory.oom_control on v1 kernels >= 4.13).
+bool read_oom_kill_count(const ContainerState& state, unsigned long long& count) {
+    std::string relative_path = cgroup_relative_path(state.id, container_cgroup_hint(state));
+    if (is_cgroup_v2()) {
+        return read_cgroup_keyed_value(CGROUP_BASE_PATH + relative_path + "/memory.events", "oom_kill", count);
+    }
+    return read_cgroup_keyed_value(CGROUP_BASE_PATH + "memory/" + relative_path + "/memory.oom_control",
+                                   "oom_kill", count);
+}
+
+// Reads avg10 of the "some" or "full" line of a PSI file (memory.pressure).
+bool parse_psi_avg10(std::istream& psi, const std::string& kind, double& avg10) {
+    std::string line;
+    while (std::getline(psi, line)) {
+        std::istringstream iss(line);
+        std::string line_kind;
+        std::string field;
+        if (!(iss >> line_kind) || line_kind != kind) {
+            continue;
+        }
+        while (iss >> field) {
+            if (field.rfind("avg10=", 0) == 0) {
+                try {
+                    avg10 = std::stod(field.substr(6));
+                    return true;
+                } catch (const std::exception&) {
+                    return false;
+                }
+            }
+        }
+    }
+    return false;
+}
+
+// State carried across polls by `events --follow` to turn memory counters into events.
+struct MemoryEventWatch {
+    bool watch_oom = false;
+    unsigned long long oom_kills = 0;
+    bool watch_high = false;
+    unsigned long long high_events = 0;
+    double pressure_threshold = 0;
+    bool above_pressure = false;
+};
+
+MemoryEventWatch init_memory_event_watch(const ContainerState& state) {
+    MemoryEventWatch watch;
+    watch.watch_oom = read_oom_kill_count(state, watch.oom_kills);
+    if (is_cgroup_v2()) {
+        watch.watch_high = read_cgroup_keyed_value(container_cgroup_v2_dir(state) + "/memory.events",
+                                                   "high", watch.high_events);
+        auto it = state.annotations.find("runway.memory-pressure-threshold");
+        if (it != state.annotations.end()) {
+            try {
+                watch.pressure_threshold = std::stod(it->second);
+            } catch (const std::exception&) {
+                std::cerr << "Warning: Invalid runway.memory-pressure-threshold '" << it->second << "'" << std::endl;
+            }
+        }
+    }
+    return watch;
+}
+
+// Returns the event types and payloads that became due since the last poll.
+std::vector<std::pair<std::string, json>> poll_memory_events(const ContainerState& state, MemoryEventWatch& watch) {
+    std::vector<std::pair<std::string, json>> due;
+    unsigned long long current = 0;
+    if (watch.watch_oom && read_oom_kill_count(state, current) && current > watch.oom_kills) {
+        due.emplace_back("oom", json{{"oomKills", current}});
+        watch.oom_kills = current;
+    }
+    if (!is_cgroup_v2()) {
+        return due;
+    }
+    const std::string cgroup_dir = container_cgroup_v2_dir(state);
+    if (watch.watch_high && read_cgroup_keyed_value(cgroup_dir + "/memory.events", "high", current) &&
+        current > watch.high_events) {
+        due.emplace_back("memoryHigh", json{{"highEvents", current}});
+        watch.high_events = current;
+    }
+    if (watch.pressure_threshold > 0) {
+        std::ifstream psi(cgroup_dir + "/memory.pressure");
+        double avg10 = 0;
+        if (psi && parse_psi_avg10(psi, "some", avg10)) {
+            // Edge triggered: report once per crossing, re-arm when pressure drops.
+            if (avg10 >= watch.pressure_threshold && !watch.above_pressure) {
+                due.emplace_back("memoryPressure", json{{"avg10", avg10}, {"threshold", watch.pressure_threshold}});
+            }
+            watch.above_pressure = avg10 >= watch.pressure_threshold;
+        }
+    }
+    return due;
+}
+
+// Directory of a controller for the container: the unified directory on v2,
+// <base>/<controller>/<path> on v1.
+std::string container_controller_dir(const ContainerState& state, const std::string& controller) {
+    if (is_cgroup_v2()) {
+        return container_cgroup_v2_dir(state);
+    }
+    return CGROUP_BASE_PATH + controller + "/" + cgroup_relative_path(state.id, container_cgroup_hint(state));
+}
+
+// Tracks one threshold that must stay exceeded for `duration` before it fires.
+struct ThresholdAlert {
+    double threshold = 0;
+    std::chrono::seconds duration{0};
+    bool above = false;
+    bool fired = false;
+    std::chrono::steady_clock::time_point since;
+};
+
+// Fires once per sustained crossing and re-arms when the value drops below.
+bool update_threshold_alert(ThresholdAlert& alert, double value, std::chrono::steady_clock::time_point now) {
+    if (alert.threshold <= 0 || value < alert.threshold) {
+        alert.above = false;
+        alert.fired = false;
+        return false;
+    }
+    if (!alert.above) {
+        alert.above = true;
+        alert.since = now;
+    }
+    if (alert.fired || now - alert.since < alert.duration) {
+        return false;
+    }
+    alert.fired = true;
+    return true;
+}
+
+// Thresholds from runway.alert.* annotations, evaluated by `events --follow`.
+struct ResourceAlertWatch {
+    ThresholdAlert memory;
+    ThresholdAlert cpu_throttled;
+    unsigned long long nr_periods = 0;
+    unsigned long long nr_throttled = 0;
+};
+
+double annotation_number(const ContainerState& state, const std::string& key) {
+    auto it = state.annotations.find(key);
+    if (it == state.annotations.end()) {
+        return 0;
+    }
+    try {
+        return std::stod(it->second);
+    } catch (const std::exception&) {
+        std::cerr << "Warning: Invalid " << key << " '" << it->second << "'" << std::endl;
+        return 0;
+    }
+}
+
+ResourceAlertWatch init_resource_alert_watch(const ContainerState& state) {
+    ResourceAlertWatch watch;
+    watch.memory.threshold = annotation_number(state, "runway.alert.memory-percent");
+    watch.memory.duration = std::chrono::seconds(
+            static_cast<long long>(annotation_number(state, "runway.alert.memory-duration")));
+    watch.cpu_throttled.threshold = annotation_number(state, "runway.alert.cpu-throttled-percent");
+    watch.cpu_throttled.duration = std::chrono::seconds(
+            static_cast<long long>(annotation_number(state, "runway.alert.cpu-throttled-duration")));
+    const std::string cpu_stat = container_controller_dir(state, "cpu") + "/cpu.stat";
+    read_cgroup_keyed_value(cpu_stat, "nr_periods", watch.nr_periods);
+    read_cgroup_keyed_value(cpu_stat, "nr_throttled", watch.nr_throttled);
+    return watch;
+}
+
+bool read_cgroup_memory_value(const std::string& path, long long& value) {
+    std::ifstream ifs(path);
+    std::string text;
+    return static_cast<bool>(ifs >> text) && parse_memory_value(text, value);
+}
+
+std::vector<std::pair<std::string, json>> poll_resource_alerts(const ContainerState& state, ResourceAlertWatch& watch) {
+    std::vector<std::pair<std::string, json>> due;
+    const auto now = std::chrono::steady_clock::now();
+
+    if (watch.memory.threshold > 0) {
+        const std::string memory_dir = container_controller_dir(state, "memory");
+        const bool v2 = is_cgroup_v2();
+        long long usage = 0;
+        long long limit = 0;
+        // Without a finite limit there is nothing to compare against.
+        if (read_cgroup_memory_value(memory_dir + (v2 ? "/memory.current" : "/memory.usage_in_bytes"), usage) &&
+            read_cgroup_memory_value(memory_dir + (v2 ? "/memory.max" : "/memory.limit_in_bytes"), limit) &&
+            limit > 0) {
+            double percent = 100.0 * static_cast<double>(usage) / static_cast<double>(limit);
+            if (update_threshold_alert(watch.memory, percent, now)) {
+                due.emplace_back("alert", json{
+                        {"resource", "memory"},
+                        {"usagePercent", percent},
+                        {"threshold", watch.memory.threshold},
+                        {"durationSeconds", watch.memory.duration.count()},
+                        {"usage", usage},
+                        {"limit", limit}
+                });
+            }
+        }
+    }
+
+    if (watch.cpu_throttled.threshold > 0) {
+        const std::string cpu_stat = container_controller_dir(state, "cpu") + "/cpu.stat";
+        unsigned long long periods = 0;
+        unsigned long long throttled = 0;
+        if (read_cgroup_keyed_value(cpu_stat, "nr_periods", periods) &&
+            read_cgroup_keyed_value(cpu_stat, "nr_throttled", throttled) &&
+            periods > watch.nr_periods) {
+            double percent = 100.0 * static_cast<double>(throttled - watch.nr_throttled) /
+                             static_cast<double>(periods - watch.nr_periods);
+            watch.nr_periods = periods;
+            watch.nr_throttled = throttled;
+            if (update_threshold_alert(watch.cpu_throttled, percent, now)) {
+                due.emplace_back("alert", json{
+                        {"resource", "cpu"},
+                        {"throttledPercent", percent},
+                        {"threshold", watch.cpu_throttled.threshold},
+                        {"durationSeconds", watch.cpu_throttled.duration.count()}
+                });
+            }
+        }
+    }
+    return due;
+}
+
+const int OOM_SCORE_ADJ_MIN = -1000;
+const int OOM_SCORE_ADJ_MAX = 1000;
+
+bool write_oom_score_adj(pid_t pid, int value) {
+    std::ofstream ofs("/proc/" + std::to_string(pid) + "/oom_score_adj");
+    if (!ofs) {
+        return false;
+    }
+    ofs << value << std::endl;
+    return static_cast<bool>(ofs);
+}
+
 //seccomp系アタッチ
 //void attach_bpf(pid_t pid, int& syscalls[], bool isActive){
 //    //Todo: BPF処理を外部実装
 //}
 
 // 制限のアタッチ
+bool has_memory_resources(const LinuxResourcesConfig& resources) {
+    return resources.memory_limit > 0 || resources.memory_reservation != 0 ||
+           resources.memory_min != 0 || resources.memory_high != 0;
+}
+
+// Writes the memory settings that are set in `resources` into a memory cgroup
+// directory (the unified directory on v2, the memory controller on v1).
+void apply_memory_resources(const std::string& cgroup_dir, const LinuxResourcesConfig& resources) {
+    if (is_cgroup_v2()) {
+        if (resources.memory_min != 0) {
+            write_cgroup_file(cgroup_dir + "/memory.min", memory_value_string(resources.memory_min));
+        }
+        if (resources.memory_reservation != 0) {
+            write_cgroup_file(cgroup_dir + "/memory.low", memory_value_string(resources.memory_reservation));
+        }
+        if (resources.memory_high != 0) {
+            write_cgroup_file(cgroup_dir + "/memory.high", memory_value_string(resources.memory_high));
+        }
+        if (resources.memory_limit > 0) {
+            write_cgroup_file(cgroup_dir + "/memory.max", std::to_string(resources.memory_limit));
+        }
+        return;
+    }
+    if (resources.memory_min != 0 || resources.memory_high != 0) {
+        std::cerr << "Warning: memory.min/memory.high require cgroup v2; ignoring." << std::endl;
+    }
+    if (resources.memory_limit > 0) {
+        write_cgroup_file(cgroup_dir + "/memory.limit_in_bytes", std::to_string(resources.memory_limit));
+    }
+    if (resources.memory_reservation != 0) {
+        write_cgroup_file(cgroup_dir + "/memory.soft_limit_in_bytes", memory_v1_value_string(resources.memory_reservation));
+    }
+}
+
 void setup_cgroups(pid_t pid,
                    const std::string& id,
                    const LinuxConfig& linux_config,
                    std::string& out_relative_path) {
     log_debug("Setting up cgroups for container " + id);
 
-    std::string relative_path = linux_config.cgroups_path;
-    if (!relative_path.empty() && relative_path.front() == '/') {
-        relative_path.erase(0, 1);
-    }
-    while (!relative_path.empty() && relative_path.back() == '/') {
-        relative_path.pop_back();
-    }
-    if (relative_path.empty()) {
-        relative_path = "my_runtime/" + id;
-    }
+    std::string relative_path = cgroup_relative_path(id, linux_config.cgroups_path);
     out_relative_path = relative_path;
 
     const std::string controllers_file = CGROUP_BASE_PATH + "cgroup.controllers";
-    bool is_cgroup_v2 = (access(controllers_file.c_str(), F_OK) == 0);
 
-    if (is_cgroup_v2) {
+    if (is_cgroup_v2()) {
         std::set<std::string> available_controllers;
         std::ifstream ctrl_stream(controllers_file);
         if (ctrl_stream) {
@@ -576,7 +1330,7 @@ void setup_cgroups(pid_t pid,
         }
 
         std::vector<std::string> required_controllers;
-        if (linux_config.resources.memory_limit > 0) {
+        if (has_memory_resources(linux_config.resources)) {
             if (!available_controllers.count("memory")) {
                 throw std::runtime_error("memory controller not available in cgroup v2");
             }
@@ -601,8 +1355,8 @@ void setup_cgroups(pid_t pid,
             throw std::system_error(errno, std::system_category(), "Failed to create unified cgroup dir");
         }
 
-        if (linux_config.resources.memory_limit > 0) {
-            write_cgroup_file(unified_path + "/memory.max", std::to_string(linux_config.resources.memory_limit));
+        if (has_memory_resources(linux_config.resources)) {
+            apply_memory_resources(unified_path, linux_config.resources);
         }
         if (linux_config.resources.cpu_shares > 0) {
             unsigned long weight = cpu_shares_to_weight(linux_config.resources.cpu_shares);
@@ -614,12 +1368,12 @@ void setup_cgroups(pid_t pid,
     }
 
     // Memory Cgroup
-    if (linux_config.resources.memory_limit > 0) {
+    if (has_memory_resources(linux_config.resources)) {
         std::string mem_cgroup_path = CGROUP_BASE_PATH + "memory/" + relative_path;
         if (!ensure_directory(mem_cgroup_path, 0755)) {
             throw std::system_error(errno, std::system_category(), "Failed to create memory cgroup dir");
         }
-        write_cgroup_file(mem_cgroup_path + "/memory.limit_in_bytes", std::to_string(linux_config.resources.memory_limit));
+        apply_memory_resources(mem_cgroup_path, linux_config.resources);
         write_cgroup_file(mem_cgroup_path + "/cgroup.procs", std::to_string(pid));
     }
 
@@ -637,35 +1391,23 @@ void setup_cgroups(pid_t pid,
 // Cleans up cgroups for the container
 void cleanup_cgroups(const std::string& id, const std::string& relative_path_hint) {
     log_debug("Cleaning up cgroups for container " + id);
-    std::string relative_path = relative_path_hint;
-    if (!relative_path.empty() && relative_path.front() == '/') {
-        relative_path.erase(0, 1);
-    }
-    while (!relative_path.empty() && relative_path.back() == '/') {
-        relative_path.pop_back();
-    }
-    if (relative_path.empty()) {
-        relative_path = "my_runtime/" + id;
-    }
-
-    const std::string controllers_file = CGROUP_BASE_PATH + "cgroup.controllers";
-    bool is_cgroup_v2 = (access(controllers_file.c_str(), F_OK) == 0);
+    std::string relative_path = cgroup_relative_path(id, relative_path_hint);
 
-    if (is_cgroup_v2) {
+    if (is_cgroup_v2()) {
         std::string unified_path = CGROUP_BASE_PATH + relative_path;
         if (rmdir(unified_path.c_str()) != 0 && errno != ENOENT) {
-            perror(("Failed to remove cgroup dir: " + unified_path).c_str());
+            log_errno(("Failed to remove cgroup dir: " + unified_path).c_str());
         }
         return;
     }
 
     std::string mem_cgroup_path = CGROUP_BASE_PATH + "memory/" + relative_path;
     if (rmdir(mem_cgroup_path.c_str()) != 0 && errno != ENOENT) {
-        perror(("Failed to remove memory cgroup dir: " + mem_cgroup_path).c_str());
+        log_errno(("Failed to remove memory cgroup dir: " + mem_cgroup_path).c_str());
     }
     std::string cpu_cgroup_path = CGROUP_BASE_PATH + "cpu/" + relative_path;
     if (rmdir(cpu_cgroup_path.c_str()) != 0 && errno != ENOENT) {
-        perror(("Failed to remove cpu cgroup dir: " + cpu_cgroup_path).c_str());
+        log_errno(("Failed to remove cpu cgroup dir: " + cpu_cgroup_path).c_str());
     }
 }
 
@@ -771,6 +1513,106 @@ bool send_console_fd(const ConsolePair& pair, const std::string& socket_path, st
     return true;
 }
 
+// sd_notify 中継: コンテナ内からは /run/notify/notify.sock として見える
+const std::string CONTAINER_NOTIFY_DIR = "/run/notify";
+const std::string CONTAINER_NOTIFY_SOCKET = CONTAINER_NOTIFY_DIR + "/notify.sock";
+
+std::string notify_dir_path(const std::string& id) {
+    return state_base_path() + id + "/notify";
+}
+
+std::string notify_socket_path(const std::string& id) {
+    return notify_dir_path(id) + "/notify.sock";
+}
+
+int bind_notify_socket(const std::string& path, std::string& error_message) {
+    sockaddr_un addr{};
+    addr.sun_family = AF_UNIX;
+    if (path.size() >= sizeof(addr.sun_path)) {
+        error_message = "notify socket path too long: " + path;
+        return -1;
+    }
+    std::strncpy(addr.sun_path, path.c_str(), sizeof(addr.sun_path) - 1);
+    int sock = socket(AF_UNIX, SOCK_DGRAM | SOCK_CLOEXEC, 0);
+    if (sock == -1) {
+        error_message = std::string("notify socket creation failed: ") + std::strerror(errno);
+        return -1;
+    }
+    unlink(path.c_str());
+    if (bind(sock, reinterpret_cast<sockaddr*>(&addr), sizeof(addr)) != 0) {
+        error_message = std::string("bind notify socket failed: ") + std::strerror(errno);
+        close(sock);
+        return -1;
+    }
+    // The container may run as any uid; let it reach the socket.
+    chmod(path.c_str(), 0777);
+    return sock;
+}
+
+bool forward_notify_message(const std::string& target, const std::string& message) {
+    sockaddr_un addr{};
+    addr.sun_family = AF_UNIX;
+    if (target.empty() || target.size() >= sizeof(addr.sun_path)) {
+        return false;
+    }
+    std::strncpy(addr.sun_path, target.c_str(), sizeof(addr.sun_path) - 1);
+    int sock = socket(AF_UNIX, SOCK_DGRAM | SOCK_CLOEXEC, 0);
+    if (sock == -1) {
+        return false;
+    }
+    ssize_t sent = sendto(sock, message.data(), message.size(), 0,
+                          reinterpret_cast<sockaddr*>(&addr), sizeof(addr));
+    close(sock);
+    return sent == static_cast<ssize_t>(message.size());
+}
+
+bool notify_message_is_ready(const std::string& message) {
+    std::istringstream iss(message);
+    std::string line;
+    while (std::getline(iss, line)) {
+        if (line == "READY=1") {
+            return true;
+        }
+    }
+    return false;
+}
+
+// Runs in a detached child: waits for READY=1 from the container, records a
+// "ready" event and forwards the notification to the caller's socket.
+void watch_notify_socket(int sock, const std::string& id, pid_t container_pid, const std::string& forward_to) {
+    char buffer[4096];
+    while (true) {
+        struct pollfd pfd{};
+        pfd.fd = sock;
+        pfd.events = POLLIN;
+        int ready = poll(&pfd, 1, 1000);
+        if (ready < 0 && errno != EINTR) {
+            return;
+        }
+        if (ready <= 0) {
+            if (kill(container_pid, 0) != 0 && errno == ESRCH) {
+                return;
+            }
+            continue;
+        }
+        ssize_t n = recv(sock, buffer, sizeof(buffer), 0);
+        if (n <= 0) {
+            continue;
+        }
+        if (!notify_message_is_ready(std::string(buffer, static_cast<size_t>(n)))) {
+            continue;
+        }
+        record_event(id, "ready", json{{"pid", container_pid}});
+        if (!forward_to.empty()) {
+            std::string message = "READY=1\nMAINPID=" + std::to_string(container_pid) + "\n";
+            if (!forward_notify_message(forward_to, message)) {
+                record_event(id, "error", json{{"phase", "notify"}, {"message", "Failed to forward READY=1 to " + forward_to}});
+            }
+        }
+        return;
+    }
+}
+
 std::string iso8601_now() {
     using namespace std::chrono;
     auto now = system_clock::now();
@@ -834,13 +1676,13 @@ bool execute_single_hook(const HookConfig& hook,
     }
     int pipe_fds[2];
     if (pipe(pipe_fds) != 0) {
-        perror("pipe for hook stdin failed");
+        log_errno("pipe for hook stdin failed");
         return false;
     }
 
     pid_t pid = fork();
     if (pid == -1) {
-        perror("fork for hook failed");
+        log_errno("fork for hook failed");
         close(pipe_fds[0]);
         close(pipe_fds[1]);
         return false;
@@ -849,7 +1691,7 @@ bool execute_single_hook(const HookConfig& hook,
     if (pid == 0) {
         close(pipe_fds[1]);
         if (dup2(pipe_fds[0], STDIN_FILENO) == -1) {
-            perror("dup2 failed for hook stdin");
+            log_errno("dup2 failed for hook stdin");
             _exit(127);
         }
         close(pipe_fds[0]);
@@ -883,7 +1725,7 @@ bool execute_single_hook(const HookConfig& hook,
         envp.push_back(nullptr);
 
         execve(hook.path.c_str(), argv.data(), envp.data());
-        perror(("Failed to exec hook: " + hook.path).c_str());
+        log_errno(("Failed to exec hook: " + hook.path).c_str());
         _exit(127);
     }
 
@@ -979,12 +1821,12 @@ bool write_mapping_file(const std::string& path, const std::vector<LinuxIDMappin
     }
     std::ofstream ofs(path);
     if (!ofs) {
-        perror(("Failed to open " + path).c_str());
+        log_errno(("Failed to open " + path).c_str());
         return false;
     }
     ofs << format_id_mappings(mappings);
     if (!ofs.good()) {
-        perror(("Failed to write " + path).c_str());
+        log_errno(("Failed to write " + path).c_str());
         return false;
     }
     return true;
@@ -1005,11 +1847,11 @@ bool configure_user_namespace(pid_t pid,
         if (setgroups_file) {
             setgroups_file << "deny\n";
             if (!setgroups_file.good()) {
-                perror(("Failed to write " + proc_prefix + "/setgroups").c_str());
+                log_errno(("Failed to write " + proc_prefix + "/setgroups").c_str());
                 return false;
             }
         } else if (errno != ENOENT) {
-            perror(("Failed to open " + proc_prefix + "/setgroups").c_str());
+            log_errno(("Failed to open " + proc_prefix + "/setgroups").c_str());
             return false;
         }
     }
@@ -1041,6 +1883,8 @@ struct ParsedMountOptions {
     unsigned long propagation = 0;
     bool has_propagation = false;
     bool bind_readonly = false;
+    bool idmap = false;
+    bool idmap_recursive = false;
     std::string data;
 };
 
@@ -1116,6 +1960,11 @@ ParsedMountOptions parse_mount_options(const std::vector<std::string>& options)
         } else if (opt == "runbindable") {
             parsed.propagation = MS_UNBINDABLE | MS_REC;
             parsed.has_propagation = true;
+        } else if (opt == "idmap") {
+            parsed.idmap = true;
+        } else if (opt == "ridmap") {
+            parsed.idmap = true;
+            parsed.idmap_recursive = true;
         } else if (opt.find('=') != std::string::npos) {
             data_options.push_back(opt);
         } else {
@@ -1261,7 +2110,7 @@ bool apply_mount_propagation(const std::string& path, const std::string& propaga
         return false;
     }
     if (mount(nullptr, path.c_str(), nullptr, flag, nullptr) != 0) {
-        perror(("Failed to set propagation on " + path).c_str());
+        log_errno(("Failed to set propagation on " + path).c_str());
         return false;
     }
     return true;
@@ -1269,24 +2118,162 @@ bool apply_mount_propagation(const std::string& path, const std::string& propaga
 
 
 // Entry point for the child process (container)
+// struct mount_attr from linux/mount.h, which clashes with sys/mount.h.
+struct IdmapMountAttr {
+    uint64_t attr_set;
+    uint64_t attr_clr;
+    uint64_t propagation;
+    uint64_t userns_fd;
+};
+
+// Detached copy of `source` whose ownership is shifted through `userns_fd`.
+int create_idmapped_mount(const std::string& source, int userns_fd, bool recursive, std::string& error_message) {
+    unsigned int tree_flags = OPEN_TREE_CLONE | OPEN_TREE_CLOEXEC | (recursive ? AT_RECURSIVE : 0);
+    int fd = static_cast<int>(syscall(SYS_open_tree, AT_FDCWD, source.c_str(), tree_flags));
+    if (fd == -1) {
+        error_message = "open_tree " + source + " failed: " + std::strerror(errno);
+        return -1;
+    }
+    IdmapMountAttr attr{};
+    attr.attr_set = MOUNT_ATTR_IDMAP;
+    attr.userns_fd = static_cast<uint64_t>(userns_fd);
+    unsigned int setattr_flags = AT_EMPTY_PATH | (recursive ? AT_RECURSIVE : 0);
+    if (syscall(SYS_mount_setattr, fd, "", setattr_flags, &attr, sizeof(attr)) != 0) {
+        error_message = "mount_setattr idmap on " + source + " failed: " + std::strerror(errno);
+        close(fd);
+        return -1;
+    }
+    return fd;
+}
+
+bool send_mount_fds(int sock, const std::vector<int>& fds, std::string& error_message) {
+    char marker = 'm';
+    struct iovec iov{};
+    iov.iov_base = &marker;
+    iov.iov_len = 1;
+
+    std::vector<char> control(CMSG_SPACE(sizeof(int) * fds.size()), 0);
+    struct msghdr msg{};
+    msg.msg_iov = &iov;
+    msg.msg_iovlen = 1;
+    msg.msg_control = control.data();
+    msg.msg_controllen = control.size();
+
+    struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+    cmsg->cmsg_level = SOL_SOCKET;
+    cmsg->cmsg_type = SCM_RIGHTS;
+    cmsg->cmsg_len = CMSG_LEN(sizeof(int) * fds.size());
+    std::memcpy(CMSG_DATA(cmsg), fds.data(), sizeof(int) * fds.size());
+
+    if (sendmsg(sock, &msg, 0) == -1) {
+        error_message = std::string("sendmsg of idmapped mounts failed: ") + std::strerror(errno);
+        return false;
+    }
+    return true;
+}
+
+bool receive_mount_fds(int sock, size_t count, std::vector<int>& fds) {
+    char marker = 0;
+    struct iovec iov{};
+    iov.iov_base = &marker;
+    iov.iov_len = 1;
+
+    std::vector<char> control(CMSG_SPACE(sizeof(int) * count), 0);
+    struct msghdr msg{};
+    msg.msg_iov = &iov;
+    msg.msg_iovlen = 1;
+    msg.msg_control = control.data();
+    msg.msg_controllen = control.size();
+
+    if (recvmsg(sock, &msg, MSG_CMSG_CLOEXEC) <= 0) {
+        return false;
+    }
+    struct cmsghdr* cmsg = CMSG_FIRSTHDR(&msg);
+    if (cmsg == nullptr || cmsg->cmsg_type != SCM_RIGHTS ||
+        cmsg->cmsg_len != CMSG_LEN(sizeof(int) * count)) {
+        errno = EPROTO;
+        return false;
+    }
+    fds.resize(count);
+    std::memcpy(fds.data(), CMSG_DATA(cmsg), sizeof(int) * count);
+    return true;
+}
+
+// Prepares the idmapped mounts against the container's user namespace, which
+// must already have its uid/gid maps, and hands them to the container process.
+bool send_idmapped_mounts(const std::string& userns_path,
+                          const std::vector<std::pair<std::string, bool>>& sources,
+                          int sock,
+                          std::string& error_message) {
+    int userns_fd = open(userns_path.c_str(), O_RDONLY | O_CLOEXEC);
+    if (userns_fd == -1) {
+        error_message = "Failed to open " + userns_path + ": " + std::strerror(errno);
+        return false;
+    }
+    std::vector<int> fds;
+    bool ok = true;
+    for (const auto& source : sources) {
+        int fd = create_idmapped_mount(source.first, userns_fd, source.second, error_message);
+        if (fd == -1) {
+            ok = false;
+            break;
+        }
+        fds.push_back(fd);
+    }
+    close(userns_fd);
+    if (ok) {
+        ok = send_mount_fds(sock, fds, error_message);
+    }
+    for (int fd : fds) {
+        close(fd);
+    }
+    return ok;
+}
+
+bool attach_mount_fd(int fd, const std::string& target) {
+    int rc = static_cast<int>(syscall(SYS_move_mount, fd, "", AT_FDCWD, target.c_str(), MOVE_MOUNT_F_EMPTY_PATH));
+    int saved_errno = errno;
+    close(fd);
+    errno = saved_errno;
+    return rc == 0;
+}
+
 int container_main(void* arg) {
     std::unique_ptr<ContainerArgs> args_holder(static_cast<ContainerArgs*>(arg));
     ContainerArgs* args = args_holder.get();
 
     for (auto& ns_fd : args->join_namespaces) {
         if (setns(ns_fd.first, ns_fd.second) != 0) {
-            perror("setns failed");
+            log_errno("setns failed");
             return 1;
         }
         close(ns_fd.first);
     }
     args->join_namespaces.clear();
 
+    // Idmapped mounts arrive during create, before the start signal.
+    std::vector<int> idmap_fds;
+    size_t next_idmap_fd = 0;
+    if (args->idmap_socket_fd >= 0) {
+        size_t expected = args->idmap_rootfs ? 1 : 0;
+        for (const auto& mount_cfg : args->mounts) {
+            if (parse_mount_options(mount_cfg.options).idmap) {
+                ++expected;
+            }
+        }
+        bool received = receive_mount_fds(args->idmap_socket_fd, expected, idmap_fds);
+        close(args->idmap_socket_fd);
+        if (!received) {
+            log_errno("Failed to receive idmapped mounts");
+            return 1;
+        }
+    }
+
     // 1. Wait for the start signal from the parent process
     char buf;
     int fifo_fd = open(args->sync_fifo_path.c_str(), O_RDONLY);
     if (fifo_fd == -1) {
-        perror("Failed to open FIFO (read)");
+        log_errno("Failed to open FIFO (read)");
         return 1;
     }
     if (read(fifo_fd, &buf, 1) <= 0) {
@@ -1295,15 +2282,37 @@ int container_main(void* arg) {
     }
     close(fifo_fd);
 
+    // uid_map/gid_map are written by the parent before start; become the mapped root.
+    if (args->new_user_namespace) {
+        if (setresgid(0, 0, 0) != 0 || setresuid(0, 0, 0) != 0) {
+            log_errno("Failed to switch to root in user namespace");
+            return 1;
+        }
+    }
+
+    // Unshared only now, after create moved us into the container cgroup, so
+    // the namespace root is the container's own cgroup rather than the runtime's.
+    if (args->new_cgroup_namespace) {
+        if (unshare(CLONE_NEWCGROUP) != 0) {
+            log_errno("Failed to unshare cgroup namespace");
+            return 1;
+        }
+    }
+
     // 2. Set up the environment
     if (sethostname(args->hostname.c_str(), args->hostname.length()) != 0) {
-        perror("sethostname failed");
+        log_errno("sethostname failed");
         return 1;
     }
 
     const std::string rootfs = args->rootfs_path;
-    if (mount(rootfs.c_str(), rootfs.c_str(), nullptr, MS_BIND | MS_REC, nullptr) != 0) {
-        perror("Failed to bind-mount rootfs");
+    if (args->idmap_rootfs) {
+        if (!attach_mount_fd(idmap_fds[next_idmap_fd++], rootfs)) {
+            log_errno("Failed to attach idmapped rootfs");
+            return 1;
+        }
+    } else if (mount(rootfs.c_str(), rootfs.c_str(), nullptr, MS_BIND | MS_REC, nullptr) != 0) {
+        log_errno("Failed to bind-mount rootfs");
         return 1;
     }
 
@@ -1314,7 +2323,7 @@ int container_main(void* arg) {
     }
 
     if (chdir(rootfs.c_str()) != 0) {
-        perror("chdir to rootfs failed");
+        log_errno("chdir to rootfs failed");
         return 1;
     }
 
@@ -1336,7 +2345,7 @@ int container_main(void* arg) {
             if (stat(mount_cfg.source.c_str(), &source_stat) == 0) {
                 source_is_dir = S_ISDIR(source_stat.st_mode);
             } else if (is_bind) {
-                perror(("Failed to stat mount source: " + mount_cfg.source).c_str());
+                log_errno(("Failed to stat mount source: " + mount_cfg.source).c_str());
                 return 1;
             }
         }
@@ -1360,31 +2369,36 @@ int container_main(void* arg) {
             first_flags &= ~MS_RDONLY;
         }
 
-        if (mount(source, mount_target.c_str(), fs_type,
-                  first_flags,
-                  parsed.data.empty() ? nullptr : parsed.data.c_str()) != 0) {
-            perror(("Failed to mount " + destination).c_str());
+        if (parsed.idmap) {
+            if (!attach_mount_fd(idmap_fds[next_idmap_fd++], mount_target)) {
+                log_errno(("Failed to attach idmapped mount " + destination).c_str());
+                return 1;
+            }
+        } else if (mount(source, mount_target.c_str(), fs_type,
+                         first_flags,
+                         parsed.data.empty() ? nullptr : parsed.data.c_str()) != 0) {
+            log_errno(("Failed to mount " + destination).c_str());
             return 1;
         }
 
         if (parsed.bind_readonly) {
             unsigned long remount_flags = parsed.flags | MS_REMOUNT;
             if (mount(nullptr, mount_target.c_str(), nullptr, remount_flags, nullptr) != 0) {
-                perror(("Failed to remount readonly " + destination).c_str());
+                log_errno(("Failed to remount readonly " + destination).c_str());
                 return 1;
             }
         } else if (parsed.flags & MS_REMOUNT) {
             if (mount(source, mount_target.c_str(), fs_type,
                       parsed.flags,
                       parsed.data.empty() ? nullptr : parsed.data.c_str()) != 0) {
-                perror(("Failed to remount " + destination).c_str());
+                log_errno(("Failed to remount " + destination).c_str());
                 return 1;
             }
         }
 
         if (parsed.has_propagation) {
             if (mount(nullptr, mount_target.c_str(), nullptr, parsed.propagation, nullptr) != 0) {
-                perror(("Failed to set propagation on " + destination).c_str());
+                log_errno(("Failed to set propagation on " + destination).c_str());
                 return 1;
             }
         }
@@ -1417,7 +2431,7 @@ int container_main(void* arg) {
             if (mount("tmpfs", target.c_str(), "tmpfs",
                       MS_RDONLY | MS_NOSUID | MS_NODEV | MS_NOEXEC,
                       "size=0") != 0) {
-                perror(("Failed to mask directory " + masked).c_str());
+                log_errno(("Failed to mask directory " + masked).c_str());
                 return 1;
             }
         } else {
@@ -1426,7 +2440,7 @@ int container_main(void* arg) {
                 return 1;
             }
             if (mount("/dev/null", target.c_str(), nullptr, MS_BIND, nullptr) != 0) {
-                perror(("Failed to mask file " + masked).c_str());
+                log_errno(("Failed to mask file " + masked).c_str());
                 return 1;
             }
         }
@@ -1451,11 +2465,11 @@ int container_main(void* arg) {
             }
         }
         if (mount(target.c_str(), target.c_str(), nullptr, MS_BIND | MS_REC, nullptr) != 0) {
-            perror(("Failed to bind-mount readonly path " + ro_path).c_str());
+            log_errno(("Failed to bind-mount readonly path " + ro_path).c_str());
             return 1;
         }
         if (mount(nullptr, target.c_str(), nullptr, MS_BIND | MS_REMOUNT | MS_REC | MS_RDONLY, nullptr) != 0) {
-            perror(("Failed to remount readonly path " + ro_path).c_str());
+            log_errno(("Failed to remount readonly path " + ro_path).c_str());
             return 1;
         }
     }
@@ -1466,29 +2480,29 @@ int container_main(void* arg) {
         if (!ensure_directory(old_root_dir, 0700)) {
             std::cerr << "Failed to prepare old root directory for pivot_root" << std::endl;
         } else if (syscall(SYS_pivot_root, ".", old_root_dir.c_str()) != 0) {
-            perror("pivot_root failed");
+            log_errno("pivot_root failed");
         } else {
             pivot_succeeded = true;
             if (chdir("/") != 0) {
-                perror("chdir to new root failed");
+                log_errno("chdir to new root failed");
                 return 1;
             }
             if (umount2(("/" + old_root_dir).c_str(), MNT_DETACH) != 0) {
-                perror("Failed to unmount old root");
+                log_errno("Failed to unmount old root");
             }
             if (rmdir(("/" + old_root_dir).c_str()) != 0) {
-                perror("Failed to remove old root directory");
+                log_errno("Failed to remove old root directory");
             }
         }
     }
 
     if (!pivot_succeeded) {
         if (chroot(".") != 0) {
-            perror("chroot failed");
+            log_errno("chroot failed");
             return 1;
         }
         if (chdir("/") != 0) {
-            perror("chdir to / failed");
+            log_errno("chdir to / failed");
             return 1;
         }
     }
@@ -1501,32 +2515,32 @@ int container_main(void* arg) {
 
     const std::string target_cwd = args->process_cwd.empty() ? "/" : args->process_cwd;
     if (chdir(target_cwd.c_str()) != 0) {
-        perror("Failed to set process cwd");
+        log_errno("Failed to set process cwd");
         return 1;
     }
 
     if (mount("proc", "/proc", "proc", 0, nullptr) != 0) {
-        perror("Failed to mount proc");
+        log_errno("Failed to mount proc");
     }
 
     if (args->rootfs_readonly) {
         if (mount(nullptr, "/", nullptr, MS_REMOUNT | MS_RDONLY, nullptr) != 0) {
-            perror("Failed to remount rootfs as readonly");
+            log_errno("Failed to remount rootfs as readonly");
         }
     }
 
     if (args->terminal && args->console_slave_fd >= 0) {
         if (setsid() == -1) {
-            perror("setsid failed");
+            log_errno("setsid failed");
             return 1;
         }
         if (ioctl(args->console_slave_fd, TIOCSCTTY, 0) == -1) {
-            perror("Failed to set controlling terminal");
+            log_errno("Failed to set controlling terminal");
             return 1;
         }
         for (int fd = 0; fd < 3; ++fd) {
             if (dup2(args->console_slave_fd, fd) == -1) {
-                perror("dup2 failed for console");
+                log_errno("dup2 failed for console");
                 return 1;
             }
         }
@@ -1538,7 +2552,7 @@ int container_main(void* arg) {
 
     if (!args->process_env.empty()) {
         if (clearenv() != 0) {
-            perror("clearenv failed");
+            log_errno("clearenv failed");
             return 1;
         }
         for (const auto& env_entry : args->process_env) {
@@ -1549,7 +2563,7 @@ int container_main(void* arg) {
                 continue;
             }
             if (setenv(key.c_str(), value.c_str(), 1) != 0) {
-                perror("setenv failed");
+                log_errno("setenv failed");
                 return 1;
             }
         }
@@ -1563,13 +2577,67 @@ int container_main(void* arg) {
     }
     argv.push_back(nullptr);
     if (execvp(argv[0], argv.data())) {
-        perror("execvp failed");
+        log_errno("execvp failed");
     }
 
     return 1; // Todo: ハンドリングの追加/エラーメッセージの追加
 }
 
+// Maps linux.namespaces[].type to its clone flag. OCI names ("network",
+// "mount") and the short /proc/<pid>/ns names ("net", "mnt") are both accepted.
+int namespace_clone_flag(const std::string& type) {
+    static const std::map<std::string, int> ns_map = {
+            {"pid", CLONE_NEWPID}, {"uts", CLONE_NEWUTS}, {"ipc", CLONE_NEWIPC},
+            {"network", CLONE_NEWNET}, {"net", CLONE_NEWNET},
+            {"mount", CLONE_NEWNS}, {"mnt", CLONE_NEWNS},
+            {"user", CLONE_NEWUSER}, {"cgroup", CLONE_NEWCGROUP}
+    };
+    auto it = ns_map.find(type);
+    return it == ns_map.end() ? 0 : it->second;
+}
+
+// Namespace entries create_container would skip because their type has no
+// clone flag here (e.g. "time").
+std::vector<std::string> unsupported_namespace_types(const json& spec) {
+    std::vector<std::string> fields;
+    if (!spec.contains("linux") || !spec["linux"].contains("namespaces") ||
+        !spec["linux"]["namespaces"].is_array()) {
+        return fields;
+    }
+    const json& namespaces = spec["linux"]["namespaces"];
+    for (size_t i = 0; i < namespaces.size(); ++i) {
+        const std::string type = namespaces[i].value("type", "");
+        if (namespace_clone_flag(type) == 0) {
+            fields.push_back("linux.namespaces[" + std::to_string(i) + "].type=" + type);
+        }
+    }
+    return fields;
+}
+
 // OCI `create` command
+// CLI options that are accepted for compatibility but have no effect.
+std::vector<std::string> ignored_create_options(const CreateOptions& options, bool terminal) {
+    std::vector<std::string> ignored;
+    if (options.preserve_fds > 0) {
+        ignored.emplace_back("--preserve-fds");
+    }
+    if (!options.console_socket.empty() && !terminal) {
+        ignored.emplace_back("--console-socket");
+    }
+    return ignored;
+}
+
+std::vector<std::string> ignored_exec_options(const ExecOptions& options) {
+    std::vector<std::string> ignored;
+    if (options.tty) {
+        ignored.emplace_back("--tty");
+    }
+    if (options.preserve_fds > 0) {
+        ignored.emplace_back("--preserve-fds");
+    }
+    return ignored;
+}
+
 void create_container(const CreateOptions& options) {
     const std::string& id = options.id;
     const std::string requested_bundle = options.bundle.empty() ? "." : options.bundle;
@@ -1580,24 +2648,44 @@ void create_container(const CreateOptions& options) {
         return;
     }
 
-    if (options.no_pivot) {
-        std::cerr << "Warning: --no-pivot is not supported; ignoring request." << std::endl;
-    }
-    if (options.preserve_fds > 0) {
-        std::cerr << "Warning: --preserve-fds is not supported; ignoring request." << std::endl;
-    }
-    if (!options.notify_socket.empty()) {
-        std::cerr << "Warning: --notify-socket is not supported; ignoring request." << std::endl;
-    }
-
     OCIConfig config;
     try {
-        config = load_config(bundle_path);
+        json spec = load_config_json(bundle_path);
+        if (g_global_options.strict) {
+            std::vector<std::string> unsupported = unsupported_spec_fields(spec);
+            for (const auto& field : host_unenforced_spec_fields(spec, is_cgroup_v2())) {
+                unsupported.push_back(field);
+            }
+            for (const auto& field : unsupported_namespace_types(spec)) {
+                unsupported.push_back(field);
+            }
+            if (!unsupported.empty()) {
+                std::cerr << "Error: strict mode rejects spec fields not enforced by this runtime: "
+                          << join_strings(unsupported, ", ") << std::endl;
+                return;
+            }
+        }
+        config = spec.get<OCIConfig>();
     } catch (const std::exception& e) {
         std::cerr << "Error processing config file: " << e.what() << std::endl;
         return;
     }
 
+    if (g_global_options.strict) {
+        std::vector<std::string> ignored = ignored_create_options(options, config.process.terminal);
+        if (!ignored.empty()) {
+            std::cerr << "Error: strict mode rejects options ignored by this runtime: "
+                      << join_strings(ignored, ", ") << std::endl;
+            return;
+        }
+    }
+    if (options.no_pivot) {
+        std::cerr << "Warning: --no-pivot is not supported; ignoring request." << std::endl;
+    }
+    if (options.preserve_fds > 0) {
+        std::cerr << "Warning: --preserve-fds is not supported; ignoring request." << std::endl;
+    }
+
     ContainerState state;
     state.oci_version = config.ociVersion;
     state.version = config.ociVersion.empty() ? RUNTIME_VERSION : config.ociVersion;
@@ -1615,8 +2703,15 @@ void create_container(const CreateOptions& options) {
     bool console_allocated = false;
     std::string container_dir = state_base_path() + id;
     std::string fifo_path = get_fifo_path(id);
+    int idmap_sockets[2] = {-1, -1};
 
     auto cleanup_failure = [&](const std::string& phase, const std::string& message = "") {
+        for (int& sock : idmap_sockets) {
+            if (sock >= 0) {
+                close(sock);
+                sock = -1;
+            }
+        }
         if (!message.empty()) {
             std::cerr << message << std::endl;
         }
@@ -1634,6 +2729,9 @@ void create_container(const CreateOptions& options) {
             std::string state_file_path = container_dir + "/state.json";
             unlink(state_file_path.c_str());
         }
+        if (!options.notify_socket.empty()) {
+            rmdir(notify_dir_path(id).c_str());
+        }
         rmdir(container_dir.c_str());
         close_console_pair(console_pair);
         json event_data = json{{"phase", phase}};
@@ -1644,7 +2742,7 @@ void create_container(const CreateOptions& options) {
     };
 
     if (mkdir(container_dir.c_str(), 0755) != 0 && errno != EEXIST) {
-        perror("Failed to create container directory"); return;
+        log_errno("Failed to create container directory"); return;
     }
 
     record_state_event(state);
@@ -1655,7 +2753,7 @@ void create_container(const CreateOptions& options) {
     }
 
     if (mkfifo(fifo_path.c_str(), 0666) == -1 && errno != EEXIST) {
-        perror("mkfifo failed");
+        log_errno("mkfifo failed");
         cleanup_failure("create", "Failed to create container FIFO");
         return;
     }
@@ -1682,6 +2780,20 @@ void create_container(const CreateOptions& options) {
     args->rootfs_propagation = config.linux.rootfs_propagation;
     args->process_args = config.process.args;
     args->process_env = config.process.env;
+    if (!options.notify_socket.empty()) {
+        if (!ensure_directory(notify_dir_path(id), 0755)) {
+            cleanup_failure("notify", "Failed to create notify socket directory");
+            return;
+        }
+        MountConfig notify_mount;
+        notify_mount.destination = CONTAINER_NOTIFY_DIR;
+        notify_mount.type = "bind";
+        notify_mount.source = notify_dir_path(id);
+        notify_mount.options = {"bind", "nosuid", "nodev", "noexec"};
+        args->mounts.push_back(notify_mount);
+        args->process_env.push_back("NOTIFY_SOCKET=" + CONTAINER_NOTIFY_SOCKET);
+        state.annotations["runway.notifySocket"] = options.notify_socket;
+    }
     args->process_cwd = config.process.cwd.empty() ? "/" : config.process.cwd;
     args->terminal = config.process.terminal;
     if (args->terminal) {
@@ -1707,33 +2819,46 @@ void create_container(const CreateOptions& options) {
 
     int flags = SIGCHLD;
     bool creates_new_userns = false;
-    std::map<std::string, int> ns_map = {
-            {"pid", CLONE_NEWPID}, {"uts", CLONE_NEWUTS}, {"ipc", CLONE_NEWIPC},
-            {"net", CLONE_NEWNET}, {"mnt", CLONE_NEWNS}, {"user", CLONE_NEWUSER},
-            {"cgroup", CLONE_NEWCGROUP}
-    };
 
     for (const auto& ns : config.linux.namespaces) {
-        auto it = ns_map.find(ns.type);
-        if (it == ns_map.end()) {
+        int ns_flag = namespace_clone_flag(ns.type);
+        if (ns_flag == 0) {
+            std::cerr << "Warning: Unknown namespace type '" << ns.type << "'; ignoring." << std::endl;
             continue;
         }
-        int ns_flag = it->second;
         if (!ns.path.empty()) {
             int fd = open(ns.path.c_str(), O_RDONLY | O_CLOEXEC);
             if (fd == -1) {
-                perror(("Failed to open namespace path: " + ns.path).c_str());
+                log_errno(("Failed to open namespace path: " + ns.path).c_str());
                 cleanup_failure("namespace", "Failed to open namespace path: " + ns.path);
                 return;
             }
+            // Catch e.g. a pid namespace passed as the network namespace before clone.
+            int actual_type = ioctl(fd, NS_GET_NSTYPE);
+            if (actual_type != -1 && actual_type != ns_flag) {
+                close(fd);
+                cleanup_failure("namespace", "Namespace path " + ns.path + " is not a " + ns.type + " namespace");
+                return;
+            }
             args->join_namespaces.emplace_back(fd, ns_flag);
             continue;
         }
+        if (ns_flag == CLONE_NEWCGROUP) {
+            args->new_cgroup_namespace = true;
+            continue;
+        }
         flags |= ns_flag;
         if (ns_flag == CLONE_NEWUSER) {
             creates_new_userns = true;
         }
     }
+    if (g_global_options.private_cgroupns) {
+        bool joins_cgroupns = false;
+        for (const auto& ns_fd : args->join_namespaces) {
+            joins_cgroupns = joins_cgroupns || ns_fd.second == CLONE_NEWCGROUP;
+        }
+        args->new_cgroup_namespace = !joins_cgroupns;
+    }
 
     std::vector<LinuxIDMapping> uid_mappings = config.linux.uid_mappings;
     std::vector<LinuxIDMapping> gid_mappings = config.linux.gid_mappings;
@@ -1754,6 +2879,47 @@ void create_container(const CreateOptions& options) {
         }
     }
 
+    args->new_user_namespace = creates_new_userns;
+
+    // Idmapped mounts: the rootfs on request (runway.idmap-rootfs) and bind
+    // mounts carrying the OCI idmap/ridmap options. Paired with their recursion flag.
+    std::vector<std::pair<std::string, bool>> idmap_sources;
+    auto idmap_rootfs = config.annotations.find("runway.idmap-rootfs");
+    args->idmap_rootfs = idmap_rootfs != config.annotations.end() && idmap_rootfs->second == "true";
+    if (args->idmap_rootfs) {
+        idmap_sources.emplace_back(args->rootfs_path, true);
+    }
+    for (const auto& mount_cfg : args->mounts) {
+        ParsedMountOptions parsed = parse_mount_options(mount_cfg.options);
+        if (!parsed.idmap) {
+            continue;
+        }
+        if (!(parsed.flags & MS_BIND) && mount_cfg.type != "bind") {
+            cleanup_failure("idmap", "idmap/ridmap is only supported on bind mounts: " + mount_cfg.destination);
+            return;
+        }
+        bool recursive = parsed.idmap_recursive || (parsed.flags & MS_REC);
+        idmap_sources.emplace_back(mount_cfg.source, recursive);
+    }
+    std::string userns_path;
+    if (!idmap_sources.empty()) {
+        for (const auto& ns : config.linux.namespaces) {
+            if (namespace_clone_flag(ns.type) == CLONE_NEWUSER && !ns.path.empty()) {
+                userns_path = ns.path;
+            }
+        }
+        if (!creates_new_userns && userns_path.empty()) {
+            cleanup_failure("idmap", "Idmapped mounts require a user namespace");
+            return;
+        }
+        if (socketpair(AF_UNIX, SOCK_STREAM | SOCK_CLOEXEC, 0, idmap_sockets) != 0) {
+            log_errno("socketpair failed");
+            cleanup_failure("idmap", "Failed to create idmapped mount socket");
+            return;
+        }
+        args->idmap_socket_fd = idmap_sockets[1];
+    }
+
     char* stack = new char[STACK_SIZE];
     char* stack_top = stack + STACK_SIZE;
 
@@ -1761,7 +2927,7 @@ void create_container(const CreateOptions& options) {
     delete[] stack;
 
     if (pid == -1) {
-        perror("clone failed");
+        log_errno("clone failed");
         cleanup_failure("clone", "Failed to clone container process");
         return;
     }
@@ -1769,6 +2935,20 @@ void create_container(const CreateOptions& options) {
         cleanup_failure("userNamespace", "Failed to configure user namespace");
         return;
     }
+    if (!idmap_sources.empty()) {
+        close(idmap_sockets[1]);
+        idmap_sockets[1] = -1;
+        if (userns_path.empty()) {
+            userns_path = "/proc/" + std::to_string(pid) + "/ns/user";
+        }
+        std::string idmap_error;
+        if (!send_idmapped_mounts(userns_path, idmap_sources, idmap_sockets[0], idmap_error)) {
+            cleanup_failure("idmap", idmap_error);
+            return;
+        }
+        close(idmap_sockets[0]);
+        idmap_sockets[0] = -1;
+    }
     args.release();
 
     if (console_allocated && console_pair.slave_fd >= 0) {
@@ -1788,6 +2968,14 @@ void create_container(const CreateOptions& options) {
         console_allocated = false;
     }
 
+    if (config.process.has_oom_score_adj) {
+        if (!write_oom_score_adj(pid, config.process.oom_score_adj)) {
+            cleanup_failure("oomScoreAdj", "Failed to set oom_score_adj to " + std::to_string(config.process.oom_score_adj));
+            return;
+        }
+        state.annotations["runway.oomScoreAdj"] = std::to_string(config.process.oom_score_adj);
+    }
+
     // Cgroupの設定系
     try {
         setup_cgroups(pid, id, config.linux, cgroup_relative_path);
@@ -1987,19 +3175,106 @@ bool parse_events_options(int argc, char* const argv[], EventsOptions& options)
                         options.interval_ms = 1000;
                     }
                 } catch (const std::exception&) {
-                    std::cerr << "Invalid value for --interval: " << optarg << std::endl;
+                    std::cerr << "Invalid value for --interval: " << optarg << std::endl;
+                    optind = 1;
+                    return false;
+                }
+                break;
+            case '?': {
+                int idx = std::max(0, optind - 1);
+                std::cerr << "Unknown events option: " << argv[idx] << std::endl;
+                optind = 1;
+                return false;
+            }
+            default:
+                std::cerr << "Unknown events option encountered." << std::endl;
+                optind = 1;
+                return false;
+        }
+    }
+
+    if (optind >= argc) {
+        std::cerr << "Error: Container id is required." << std::endl;
+        optind = 1;
+        return false;
+    }
+    options.id = argv[optind++];
+    if (optind < argc) {
+        std::cerr << "Error: Unexpected argument: " << argv[optind] << std::endl;
+        optind = 1;
+        return false;
+    }
+
+    optind = 1;
+    return true;
+}
+
+bool parse_update_options(int argc, char* const argv[], UpdateOptions& options) {
+    static struct option update_long_options[] = {
+            {"oom-score-adj", required_argument, nullptr, 'o'},
+            {"memory", required_argument, nullptr, 'm'},
+            {"memory-reservation", required_argument, nullptr, 'r'},
+            {"memory-low", required_argument, nullptr, 'r'},
+            {"memory-min", required_argument, nullptr, 'n'},
+            {"memory-high", required_argument, nullptr, 'H'},
+            {nullptr, 0, nullptr, 0}
+    };
+
+    opterr = 0;
+    optind = 1;
+
+    int option;
+    while ((option = getopt_long(argc, argv, "+", update_long_options, nullptr)) != -1) {
+        switch (option) {
+            case 'o':
+                try {
+                    size_t consumed = 0;
+                    options.oom_score_adj = std::stoi(optarg, &consumed);
+                    if (consumed != std::strlen(optarg)) {
+                        options.oom_score_adj = OOM_SCORE_ADJ_MIN - 1;
+                    }
+                } catch (const std::exception&) {
+                    options.oom_score_adj = OOM_SCORE_ADJ_MIN - 1;
+                }
+                if (options.oom_score_adj < OOM_SCORE_ADJ_MIN || options.oom_score_adj > OOM_SCORE_ADJ_MAX) {
+                    std::cerr << "Invalid value for --oom-score-adj: " << optarg
+                              << " (expected " << OOM_SCORE_ADJ_MIN << ".." << OOM_SCORE_ADJ_MAX << ")" << std::endl;
+                    optind = 1;
+                    return false;
+                }
+                options.has_oom_score_adj = true;
+                break;
+            case 'm':
+            case 'r':
+            case 'n':
+            case 'H': {
+                long long value = 0;
+                // The hard limit must be a byte count; the soft knobs also take "max".
+                if (!parse_memory_value(optarg, value) || value == 0 || (option == 'm' && value < 0)) {
+                    int idx = std::max(0, optind - 1);
+                    std::cerr << "Invalid value for " << argv[idx] << ": " << optarg << std::endl;
                     optind = 1;
                     return false;
                 }
+                if (option == 'm') {
+                    options.resources.memory_limit = value;
+                } else if (option == 'r') {
+                    options.resources.memory_reservation = value;
+                } else if (option == 'n') {
+                    options.resources.memory_min = value;
+                } else {
+                    options.resources.memory_high = value;
+                }
                 break;
+            }
             case '?': {
                 int idx = std::max(0, optind - 1);
-                std::cerr << "Unknown events option: " << argv[idx] << std::endl;
+                std::cerr << "Unknown update option: " << argv[idx] << std::endl;
                 optind = 1;
                 return false;
             }
             default:
-                std::cerr << "Unknown events option encountered." << std::endl;
+                std::cerr << "Unknown update option encountered." << std::endl;
                 optind = 1;
                 return false;
         }
@@ -2028,12 +3303,14 @@ void resume_container(const std::string& id);
 void list_container_processes(const std::string& id);
 void delete_container(const std::string& id, bool force);
 void events_command(const EventsOptions& options);
+bool update_container(const UpdateOptions& options);
 
 int run_container_command(int argc, char* const argv[]) {
     CreateOptions options;
     if (!parse_create_options(argc, argv, options)) {
         return 1;
     }
+    g_log_container_id = options.id;
 
     create_container(options);
 
@@ -2055,7 +3332,7 @@ int run_container_command(int argc, char* const argv[]) {
 
     int status = 0;
     if (waitpid(state.pid, &status, 0) == -1) {
-        perror("waitpid failed");
+        log_errno("waitpid failed");
         return 1;
     }
 
@@ -2119,22 +3396,63 @@ void start_container(const std::string& id, bool attach) {
         return;
     }
 
+    // Bind the notify socket before releasing the container so READY=1 is never lost.
+    int notify_fd = -1;
+    std::string notify_forward;
+    auto notify_it = state.annotations.find("runway.notifySocket");
+    if (notify_it != state.annotations.end()) {
+        notify_forward = notify_it->second;
+        std::string notify_error;
+        notify_fd = bind_notify_socket(notify_socket_path(id), notify_error);
+        if (notify_fd == -1) {
+            fail_with_event("notify", notify_error);
+            return;
+        }
+    }
+
     std::string fifo_path = get_fifo_path(id);
     int fifo_fd = open(fifo_path.c_str(), O_WRONLY);
     if (fifo_fd == -1) {
-        perror("Failed to open FIFO (write)");
+        log_errno("Failed to open FIFO (write)");
+        if (notify_fd >= 0) {
+            close(notify_fd);
+        }
         fail_with_event("start", "Failed to open FIFO for container start");
         return;
     }
 
     if (write(fifo_fd, "1", 1) != 1) {
-        perror("Failed to write to FIFO");
+        log_errno("Failed to write to FIFO");
         close(fifo_fd);
+        if (notify_fd >= 0) {
+            close(notify_fd);
+        }
         fail_with_event("start", "Failed to signal container start");
         return;
     }
     close(fifo_fd);
 
+    if (notify_fd >= 0) {
+        pid_t watcher = fork();
+        if (watcher == -1) {
+            log_errno("fork for notify watcher failed");
+        } else if (watcher == 0) {
+            setsid();
+            int devnull = open("/dev/null", O_RDWR);
+            if (devnull >= 0) {
+                dup2(devnull, STDIN_FILENO);
+                dup2(devnull, STDOUT_FILENO);
+                dup2(devnull, STDERR_FILENO);
+                if (devnull > STDERR_FILENO) {
+                    close(devnull);
+                }
+            }
+            watch_notify_socket(notify_fd, id, state.pid, notify_forward);
+            _exit(0);
+        }
+        close(notify_fd);
+    }
+
     state.status = "running";
     if (!run_hook_sequence(config.hooks.poststart, state, "poststart")) {
         fail_with_event("poststart", "poststart hooks failed");
@@ -2167,7 +3485,7 @@ void start_container(const std::string& id, bool attach) {
                     record_state_event(state);
                     break;
                 }
-                perror("Error checking container status");
+                log_errno("Error checking container status");
                 break;
             }
             std::this_thread::sleep_for(std::chrono::milliseconds(100));
@@ -2175,7 +3493,24 @@ void start_container(const std::string& id, bool attach) {
     }
 }
 
+bool same_namespace(const std::string& lhs, const std::string& rhs) {
+    struct stat lhs_stat{};
+    struct stat rhs_stat{};
+    if (stat(lhs.c_str(), &lhs_stat) != 0 || stat(rhs.c_str(), &rhs_stat) != 0) {
+        return false;
+    }
+    return lhs_stat.st_dev == rhs_stat.st_dev && lhs_stat.st_ino == rhs_stat.st_ino;
+}
+
 int exec_container(const ExecOptions& options) {
+    if (g_global_options.strict) {
+        std::vector<std::string> ignored = ignored_exec_options(options);
+        if (!ignored.empty()) {
+            std::cerr << "Error: strict mode rejects options ignored by this runtime: "
+                      << join_strings(ignored, ", ") << std::endl;
+            return 1;
+        }
+    }
     if (options.tty) {
         std::cerr << "Warning: --tty is not supported; ignoring request." << std::endl;
     }
@@ -2248,26 +3583,34 @@ int exec_container(const ExecOptions& options) {
     const std::vector<std::string> namespace_order = {"user", "mnt", "pid", "ipc", "uts", "net", "cgroup"};
     std::vector<int> namespace_fds;
     namespace_fds.reserve(namespace_order.size());
+    bool joins_userns = false;
     std::string pid_str = std::to_string(state.pid);
     for (const auto& ns_name : namespace_order) {
         std::string ns_path = "/proc/" + pid_str + "/ns/" + ns_name;
+        // setns into a namespace we already share fails with EINVAL for user namespaces.
+        if (same_namespace(ns_path, "/proc/self/ns/" + ns_name)) {
+            continue;
+        }
         int fd = open(ns_path.c_str(), O_RDONLY | O_CLOEXEC);
         if (fd == -1) {
             if (errno == ENOENT) {
                 continue;
             }
-            perror(("Failed to open namespace " + ns_name).c_str());
+            log_errno(("Failed to open namespace " + ns_name).c_str());
             for (int existing_fd : namespace_fds) {
                 close(existing_fd);
             }
             return 1;
         }
         namespace_fds.push_back(fd);
+        if (ns_name == "user") {
+            joins_userns = true;
+        }
     }
 
     pid_t child = fork();
     if (child == -1) {
-        perror("fork failed");
+        log_errno("fork failed");
         for (int fd : namespace_fds) {
             close(fd);
         }
@@ -2275,9 +3618,14 @@ int exec_container(const ExecOptions& options) {
     }
 
     if (child == 0) {
+        // Join the container cgroup from the host side so limits, kill --all and
+        // the freezer cover exec'd processes too.
+        if (!join_container_cgroup(state, getpid())) {
+            _exit(1);
+        }
         for (int fd : namespace_fds) {
             if (setns(fd, 0) != 0) {
-                perror("setns failed");
+                log_errno("setns failed");
                 _exit(1);
             }
         }
@@ -2285,16 +3633,24 @@ int exec_container(const ExecOptions& options) {
             close(fd);
         }
 
+        // Run as the container's root, i.e. whatever uid/gid 0 maps to on the host.
+        if (joins_userns) {
+            if (setresgid(0, 0, 0) != 0 || setresuid(0, 0, 0) != 0) {
+                log_errno("Failed to switch to container root in user namespace");
+                _exit(1);
+            }
+        }
+
         if (!process_cfg.cwd.empty()) {
             if (chdir(process_cfg.cwd.c_str()) != 0) {
-                perror("Failed to change working directory for exec");
+                log_errno("Failed to change working directory for exec");
                 _exit(1);
             }
         }
 
         if (!process_cfg.env.empty()) {
             if (clearenv() != 0) {
-                perror("clearenv failed for exec");
+                log_errno("clearenv failed for exec");
                 _exit(1);
             }
             for (const auto& env_entry : process_cfg.env) {
@@ -2305,7 +3661,7 @@ int exec_container(const ExecOptions& options) {
                     continue;
                 }
                 if (setenv(key.c_str(), value.c_str(), 1) != 0) {
-                    perror("setenv failed for exec");
+                    log_errno("setenv failed for exec");
                     _exit(1);
                 }
             }
@@ -2319,7 +3675,7 @@ int exec_container(const ExecOptions& options) {
         argv.push_back(nullptr);
 
         if (execvp(argv[0], argv.data()) != 0) {
-            perror("execvp failed for exec");
+            log_errno("execvp failed for exec");
             _exit(127);
         }
         _exit(127);
@@ -2347,7 +3703,7 @@ int exec_container(const ExecOptions& options) {
 
     int status = 0;
     if (waitpid(child, &status, 0) == -1) {
-        perror("waitpid failed for exec");
+        log_errno("waitpid failed for exec");
         record_event(options.id, "error", json{{"phase", "exec"}, {"message", "waitpid failed"}});
         return 1;
     }
@@ -2400,6 +3756,62 @@ std::vector<pid_t> collect_process_tree(pid_t root_pid) {
     return result;
 }
 
+// Collects every process in the container, preferring the cgroup membership
+// and falling back to the init process tree when no cgroup is available.
+std::vector<pid_t> collect_container_pids(const ContainerState& state) {
+    std::set<pid_t> unique_pids;
+    for (const auto& procs_file : container_cgroup_procs_files(state)) {
+        for (pid_t pid : read_cgroup_procs(procs_file)) {
+            unique_pids.insert(pid);
+        }
+    }
+    if (unique_pids.empty()) {
+        for (pid_t pid : collect_process_tree(state.pid)) {
+            unique_pids.insert(pid);
+        }
+    }
+    return std::vector<pid_t>(unique_pids.begin(), unique_pids.end());
+}
+
+// Process state letter from /proc/<pid>/stat ('R', 'S', 'T', ...); 0 when gone.
+char process_state(pid_t pid) {
+    std::ifstream ifs("/proc/" + std::to_string(pid) + "/stat");
+    std::string line;
+    if (!std::getline(ifs, line)) {
+        return 0;
+    }
+    auto end_paren = line.rfind(')');
+    if (end_paren == std::string::npos || end_paren + 2 >= line.size()) {
+        return 0;
+    }
+    return line[end_paren + 2];
+}
+
+bool wait_for_processes_stopped(const std::vector<pid_t>& pids, bool stopped, int timeout_ms) {
+    auto deadline = std::chrono::steady_clock::now() + std::chrono::milliseconds(timeout_ms);
+    while (true) {
+        bool settled = true;
+        for (pid_t pid : pids) {
+            char state = process_state(pid);
+            if (state == 0 || state == 'Z' || state == 'X') {
+                continue;
+            }
+            bool is_stopped = (state == 'T' || state == 't');
+            if (is_stopped != stopped) {
+                settled = false;
+                break;
+            }
+        }
+        if (settled) {
+            return true;
+        }
+        if (std::chrono::steady_clock::now() >= deadline) {
+            return false;
+        }
+        std::this_thread::sleep_for(std::chrono::milliseconds(10));
+    }
+}
+
 void pause_container(const std::string& id) {
     ContainerState state;
     try {
@@ -2418,20 +3830,40 @@ void pause_container(const std::string& id) {
         return;
     }
 
-    std::vector<pid_t> pids = collect_process_tree(state.pid);
-    bool failed = false;
-    for (pid_t pid : pids) {
-        if (kill(pid, SIGSTOP) != 0 && errno != ESRCH) {
-            perror(("Failed to pause pid " + std::to_string(pid)).c_str());
-            failed = true;
+    const std::string cgroup_dir = container_cgroup_v2_dir(state);
+    bool frozen = is_cgroup_v2() && write_cgroup_freeze(cgroup_dir, true);
+    if (frozen) {
+        if (!wait_for_cgroup_frozen(cgroup_dir, true, FREEZER_TIMEOUT_MS)) {
+            write_cgroup_freeze(cgroup_dir, false);
+            std::cerr << "Error: Timed out waiting for container cgroup to freeze." << std::endl;
+            record_event(id, "error", json{{"phase", "pause"}, {"message", "Timed out waiting for cgroup freeze"}});
+            return;
+        }
+    } else {
+        std::vector<pid_t> pids = collect_container_pids(state);
+        bool failed = false;
+        for (pid_t pid : pids) {
+            if (kill(pid, SIGSTOP) != 0 && errno != ESRCH) {
+                log_errno(("Failed to pause pid " + std::to_string(pid)).c_str());
+                failed = true;
+            }
+        }
+        if (failed) {
+            record_event(id, "error", json{{"phase", "pause"}, {"message", "Failed to pause all processes"}});
+            return;
+        }
+        if (!wait_for_processes_stopped(pids, true, FREEZER_TIMEOUT_MS)) {
+            for (pid_t pid : pids) {
+                kill(pid, SIGCONT);
+            }
+            std::cerr << "Error: Timed out waiting for container processes to stop." << std::endl;
+            record_event(id, "error", json{{"phase", "pause"}, {"message", "Timed out waiting for processes to stop"}});
+            return;
         }
-    }
-    if (failed) {
-        record_event(id, "error", json{{"phase", "pause"}, {"message", "Failed to pause all processes"}});
-        return;
     }
 
     state.status = "paused";
+    state.annotations["runway.pauseMethod"] = frozen ? "freezer" : "signal";
     if (!save_state(state)) {
         std::cerr << "Warning: Failed to persist paused state." << std::endl;
     }
@@ -2439,6 +3871,60 @@ void pause_container(const std::string& id) {
     log_debug("Container '" + id + "' paused.");
 }
 
+// Undoes pause with the method recorded in runway.pauseMethod.
+bool thaw_container(const ContainerState& state, std::string& error) {
+    auto method = state.annotations.find("runway.pauseMethod");
+    bool used_freezer = method != state.annotations.end() && method->second == "freezer";
+    if (used_freezer) {
+        const std::string cgroup_dir = container_cgroup_v2_dir(state);
+        if (!write_cgroup_freeze(cgroup_dir, false)) {
+            error = "Failed to thaw container cgroup";
+            return false;
+        }
+        if (!wait_for_cgroup_frozen(cgroup_dir, false, FREEZER_TIMEOUT_MS)) {
+            error = "Timed out waiting for cgroup thaw";
+            return false;
+        }
+        return true;
+    }
+    std::vector<pid_t> pids = collect_container_pids(state);
+    bool failed = false;
+    for (pid_t pid : pids) {
+        if (kill(pid, SIGCONT) != 0 && errno != ESRCH) {
+            log_errno(("Failed to resume pid " + std::to_string(pid)).c_str());
+            failed = true;
+        }
+    }
+    if (failed) {
+        error = "Failed to resume all processes";
+        return false;
+    }
+    if (!wait_for_processes_stopped(pids, false, FREEZER_TIMEOUT_MS)) {
+        error = "Timed out waiting for processes to resume";
+        return false;
+    }
+    return true;
+}
+
+// The runtime is normally not the parent of the container init, so exit is
+// detected from /proc instead of waitpid.
+bool wait_for_process_exit(pid_t pid, int timeout_ms) {
+    auto deadline = std::chrono::steady_clock::now() + std::chrono::milliseconds(timeout_ms);
+    while (true) {
+        if (waitpid(pid, nullptr, WNOHANG) == pid) {
+            return true;
+        }
+        char state = process_state(pid);
+        if (state == 0 || state == 'Z' || state == 'X') {
+            return true;
+        }
+        if (std::chrono::steady_clock::now() >= deadline) {
+            return false;
+        }
+        std::this_thread::sleep_for(std::chrono::milliseconds(10));
+    }
+}
+
 void resume_container(const std::string& id) {
     ContainerState state;
     try {
@@ -2453,20 +3939,15 @@ void resume_container(const std::string& id) {
         return;
     }
 
-    std::vector<pid_t> pids = collect_process_tree(state.pid);
-    bool failed = false;
-    for (pid_t pid : pids) {
-        if (kill(pid, SIGCONT) != 0 && errno != ESRCH) {
-            perror(("Failed to resume pid " + std::to_string(pid)).c_str());
-            failed = true;
-        }
-    }
-    if (failed) {
-        record_event(id, "error", json{{"phase", "resume"}, {"message", "Failed to resume all processes"}});
+    std::string error;
+    if (!thaw_container(state, error)) {
+        std::cerr << "Error: " << error << "." << std::endl;
+        record_event(id, "error", json{{"phase", "resume"}, {"message", error}});
         return;
     }
 
     state.status = "running";
+    state.annotations.erase("runway.pauseMethod");
     if (!save_state(state)) {
         std::cerr << "Warning: Failed to persist running state after resume." << std::endl;
     }
@@ -2474,6 +3955,103 @@ void resume_container(const std::string& id) {
     log_debug("Container '" + id + "' resumed.");
 }
 
+bool update_container(const UpdateOptions& options) {
+    ContainerState state;
+    try {
+        state = load_state(options.id);
+    } catch (const std::exception& e) {
+        std::cerr << e.what() << std::endl;
+        return false;
+    }
+
+    if (state.status != "created" && state.status != "running" && state.status != "paused") {
+        std::cerr << "Error: Cannot update container in state: " << state.status << std::endl;
+        return false;
+    }
+
+    // Reject before touching anything, so oom_score_adj is not changed by an
+    // update that cannot apply its memory settings.
+    if (has_memory_resources(options.resources) && !is_cgroup_v2() &&
+        options.resources.memory_limit <= 0 && options.resources.memory_reservation == 0) {
+        std::cerr << "Error: --memory-min and --memory-high require cgroup v2." << std::endl;
+        return false;
+    }
+
+    json changes = json::object();
+    if (options.has_oom_score_adj) {
+        // Every process in the container shares the adjustment so exec'd
+        // processes are protected the same way as init.
+        bool failed = false;
+        for (pid_t pid : collect_container_pids(state)) {
+            if (!write_oom_score_adj(pid, options.oom_score_adj) && errno != ENOENT && errno != ESRCH) {
+                log_errno(("Failed to set oom_score_adj for pid " + std::to_string(pid)).c_str());
+                failed = true;
+            }
+        }
+        if (failed) {
+            record_event(options.id, "error", json{{"phase", "update"}, {"message", "Failed to set oom_score_adj"}});
+            return false;
+        }
+        state.annotations["runway.oomScoreAdj"] = std::to_string(options.oom_score_adj);
+        changes["oomScoreAdj"] = options.oom_score_adj;
+    }
+
+    if (has_memory_resources(options.resources)) {
+        const std::string memory_dir = container_controller_dir(state, "memory");
+        try {
+            apply_memory_resources(memory_dir, options.resources);
+        } catch (const std::exception& e) {
+            std::cerr << "Error updating memory resources: " << e.what() << std::endl;
+            record_event(options.id, "error", json{{"phase", "update"}, {"message", e.what()}});
+            return false;
+        }
+        json memory = json::object();
+        if (options.resources.memory_limit > 0) {
+            memory["limit"] = options.resources.memory_limit;
+        }
+        if (options.resources.memory_reservation != 0) {
+            memory["low"] = memory_value_string(options.resources.memory_reservation);
+        }
+        if (options.resources.memory_min != 0 && is_cgroup_v2()) {
+            memory["min"] = memory_value_string(options.resources.memory_min);
+        }
+        if (options.resources.memory_high != 0 && is_cgroup_v2()) {
+            memory["high"] = memory_value_string(options.resources.memory_high);
+        }
+        changes["memory"] = memory;
+    }
+
+    if (changes.empty()) {
+        std::cerr << "Warning: No update options given." << std::endl;
+        return false;
+    }
+    if (!save_state(state)) {
+        std::cerr << "Warning: Failed to persist updated state." << std::endl;
+    }
+    record_event(options.id, "update", changes);
+    log_debug("Container '" + options.id + "' updated.");
+    return true;
+}
+
+// NSpid の最後の値がコンテナのPID名前空間から見たPID
+pid_t namespace_pid(pid_t host_pid) {
+    std::ifstream ifs("/proc/" + std::to_string(host_pid) + "/status");
+    std::string line;
+    while (std::getline(ifs, line)) {
+        if (line.rfind("NSpid:", 0) != 0) {
+            continue;
+        }
+        std::istringstream iss(line.substr(6));
+        pid_t value = -1;
+        pid_t last = -1;
+        while (iss >> value) {
+            last = value;
+        }
+        return last;
+    }
+    return -1;
+}
+
 void list_container_processes(const std::string& id) {
     ContainerState state;
     try {
@@ -2494,8 +4072,9 @@ void list_container_processes(const std::string& id) {
         return;
     }
     std::sort(pids.begin(), pids.end());
-    std::cout << "PID\tCMD" << std::endl;
+    std::cout << "PID\tNSPID\tCMD" << std::endl;
     for (pid_t pid : pids) {
+        pid_t ns_pid = namespace_pid(pid);
         std::string comm_path = "/proc/" + std::to_string(pid) + "/comm";
         std::ifstream ifs(comm_path);
         std::string cmd;
@@ -2505,7 +4084,7 @@ void list_container_processes(const std::string& id) {
         if (cmd.empty()) {
             cmd = "?";
         }
-        std::cout << pid << '\t' << cmd << std::endl;
+        std::cout << pid << '\t' << (ns_pid > 0 ? std::to_string(ns_pid) : "-") << '\t' << cmd << std::endl;
     }
 }
 
@@ -2644,6 +4223,15 @@ void events_command(const EventsOptions& options) {
         return;
     }
 
+    // Memory events (oom, memoryHigh, memoryPressure) and threshold alerts are
+    // not written to events.log; they are reported live to followers.
+    MemoryEventWatch memory_watch;
+    ResourceAlertWatch alert_watch;
+    if (has_state) {
+        memory_watch = init_memory_event_watch(state);
+        alert_watch = init_resource_alert_watch(state);
+    }
+
     events.clear();
     while (true) {
         if (std::getline(events, line)) {
@@ -2662,6 +4250,22 @@ void events_command(const EventsOptions& options) {
             events.clear();
         }
 
+        if (has_state) {
+            std::vector<std::pair<std::string, json>> due_events = poll_memory_events(state, memory_watch);
+            for (auto& alert : poll_resource_alerts(state, alert_watch)) {
+                due_events.push_back(std::move(alert));
+            }
+            for (const auto& due : due_events) {
+                json live_event = {
+                        {"timestamp", iso8601_now()},
+                        {"type", due.first},
+                        {"id", options.id},
+                        {"data", due.second}
+                };
+                std::cout << live_event.dump() << std::endl;
+            }
+        }
+
         if (has_state && state.pid > 0) {
             if (kill(state.pid, 0) != 0 && errno == ESRCH) {
                 // Container has exited; check if file still exists before exiting.
@@ -2680,6 +4284,30 @@ void events_command(const EventsOptions& options) {
         std::this_thread::sleep_for(std::chrono::milliseconds(options.interval_ms));
     }
 }
+// Host-side cgroup and namespace locations, so monitoring agents can attach
+// to or join the container without guessing paths.
+json container_state_details(const ContainerState& state) {
+    json details = json::object();
+    std::string relative_path = cgroup_relative_path(state.id, container_cgroup_hint(state));
+    if (is_cgroup_v2()) {
+        details["cgroup"] = {{"version", 2}, {"path", CGROUP_BASE_PATH + relative_path}};
+    } else {
+        details["cgroup"] = {{"version", 1}, {"path", relative_path}};
+    }
+    if (state.status != "stopped" && state.pid > 0) {
+        json namespaces = json::object();
+        const std::vector<std::string> ns_names = {"cgroup", "ipc", "mnt", "net", "pid", "user", "uts"};
+        for (const auto& ns_name : ns_names) {
+            std::string ns_path = "/proc/" + std::to_string(state.pid) + "/ns/" + ns_name;
+            if (access(ns_path.c_str(), F_OK) == 0) {
+                namespaces[ns_name] = ns_path;
+            }
+        }
+        details["namespaces"] = namespaces;
+    }
+    return details;
+}
+
 // OCI `state` command
 void show_state(const std::string& id) {
     try {
@@ -2690,14 +4318,109 @@ void show_state(const std::string& id) {
                 save_state(state);
             }
         }
-        std::cout << state.to_json() << std::endl;
+        json output = state.to_json_object();
+        output["runway"] = container_state_details(state);
+        std::cout << output.dump(4) << std::endl;
     } catch (const std::exception& e) {
         std::cerr << e.what() << std::endl;
     }
 }
 
-// OCI `kill` command
-void kill_container(const std::string& id, int signal) {
+// cgroup v2 (5.14+) の cgroup.kill はフォーク中のプロセスも含めて一括でSIGKILLする
+bool kill_cgroup_v2(const ContainerState& state) {
+    if (!is_cgroup_v2()) {
+        return false;
+    }
+    std::string kill_file = CGROUP_BASE_PATH + cgroup_relative_path(state.id, container_cgroup_hint(state)) +
+                            "/cgroup.kill";
+    if (access(kill_file.c_str(), W_OK) != 0) {
+        return false;
+    }
+    try {
+        write_cgroup_file(kill_file, "1");
+    } catch (const std::exception& e) {
+        log_debug(std::string("cgroup.kill failed, falling back to per-process signals: ") + e.what());
+        return false;
+    }
+    log_debug("Killed cgroup of container '" + state.id + "' via " + kill_file);
+    return true;
+}
+
+// Accepts a signal number, "SIGQUIT" or "QUIT". Returns -1 when unknown.
+int parse_signal(const std::string& value) {
+    if (value.empty()) {
+        return -1;
+    }
+    if (std::all_of(value.begin(), value.end(), [](unsigned char c) { return std::isdigit(c) != 0; })) {
+        try {
+            int number = std::stoi(value);
+            return (number >= 0 && number < NSIG) ? number : -1;
+        } catch (const std::exception&) {
+            return -1;
+        }
+    }
+    std::string name = value;
+    std::transform(name.begin(), name.end(), name.begin(), [](unsigned char c) {
+        return static_cast<char>(std::toupper(c));
+    });
+    if (name.rfind("SIG", 0) == 0) {
+        name.erase(0, 3);
+    }
+    static const std::map<std::string, int> signals = {
+            {"HUP", SIGHUP}, {"INT", SIGINT}, {"QUIT", SIGQUIT}, {"ILL", SIGILL},
+            {"TRAP", SIGTRAP}, {"ABRT", SIGABRT}, {"BUS", SIGBUS}, {"FPE", SIGFPE},
+            {"KILL", SIGKILL}, {"USR1", SIGUSR1}, {"SEGV", SIGSEGV}, {"USR2", SIGUSR2},
+            {"PIPE", SIGPIPE}, {"ALRM", SIGALRM}, {"TERM", SIGTERM}, {"CHLD", SIGCHLD},
+            {"CONT", SIGCONT}, {"STOP", SIGSTOP}, {"TSTP", SIGTSTP}, {"TTIN", SIGTTIN},
+            {"TTOU", SIGTTOU}, {"URG", SIGURG}, {"XCPU", SIGXCPU}, {"XFSZ", SIGXFSZ},
+            {"VTALRM", SIGVTALRM}, {"PROF", SIGPROF}, {"WINCH", SIGWINCH}, {"IO", SIGIO},
+            {"PWR", SIGPWR}, {"SYS", SIGSYS}
+    };
+    auto it = signals.find(name);
+    return it == signals.end() ? -1 : it->second;
+}
+
+// Signal used when kill is invoked without one; images can override it with
+// the runway.stop-signal annotation (e.g. SIGQUIT for nginx).
+int default_stop_signal(const ContainerState& state) {
+    auto it = state.annotations.find("runway.stop-signal");
+    if (it == state.annotations.end()) {
+        return SIGTERM;
+    }
+    int signal = parse_signal(it->second);
+    if (signal == -1) {
+        std::cerr << "Warning: Invalid runway.stop-signal annotation '" << it->second
+                  << "', using SIGTERM." << std::endl;
+        return SIGTERM;
+    }
+    return signal;
+}
+
+bool signal_all_processes(const ContainerState& state, int signal) {
+    if (signal == SIGKILL && kill_cgroup_v2(state)) {
+        return true;
+    }
+    std::vector<pid_t> pids = collect_container_pids(state);
+    if (pids.empty()) {
+        errno = ESRCH;
+        return false;
+    }
+    bool delivered = false;
+    for (pid_t pid : pids) {
+        if (kill(pid, signal) == 0) {
+            delivered = true;
+        } else if (errno != ESRCH) {
+            log_errno(("Failed to signal pid " + std::to_string(pid)).c_str());
+        }
+    }
+    log_debug("Sent signal " + std::to_string(signal) + " to " + std::to_string(pids.size()) +
+              " processes in container '" + state.id + "'");
+    return delivered;
+}
+
+// OCI `kill` command. A negative signal selects the container's stop signal;
+// 0 only checks that the init process is alive.
+void kill_container(const std::string& id, int signal, bool all) {
     ContainerState state;
     try {
         state = load_state(id);
@@ -2705,30 +4428,52 @@ void kill_container(const std::string& id, int signal) {
         std::cerr << e.what() << std::endl; return;
     }
 
-    if (state.status != "running" && state.status != "created") {
-        std::cerr << "Error: Container is not running or created." << std::endl;
+    if (state.status != "running" && state.status != "created" && state.status != "paused") {
+        std::cerr << "Error: Container is not running, created or paused." << std::endl;
         return;
     }
 
-    if (kill(state.pid, signal) == 0) {
+    // A default stop request waits for init like SIGTERM/SIGKILL do, whatever
+    // signal runway.stop-signal resolves to.
+    const bool stop_request = signal < 0;
+    if (stop_request) {
+        signal = default_stop_signal(state);
+    }
+
+    bool delivered = false;
+    if (all) {
+        delivered = signal_all_processes(state, signal);
+    } else if (kill(state.pid, signal) == 0) {
         log_debug("Sent signal " + std::to_string(signal) + " to process " + std::to_string(state.pid));
-        record_event(id, "signal", json{{"signal", signal}});
-        if (signal == SIGKILL || signal == SIGTERM) {
-            while (waitpid(state.pid, NULL, 0) == -1) {
-                if (errno == EINTR) {
-                    continue;
-                }
-                break;
+        delivered = true;
+    }
+
+    if (delivered) {
+        record_event(id, "signal", json{{"signal", signal}, {"all", all}});
+        const std::string previous_status = state.status;
+        // Signals other than SIGKILL stay pending on frozen or stopped tasks,
+        // so a paused container is thawed once the signal is queued.
+        if (state.status == "paused" && signal != 0) {
+            std::string thaw_error;
+            if (thaw_container(state, thaw_error)) {
+                state.status = "running";
+                state.annotations.erase("runway.pauseMethod");
+            } else {
+                std::cerr << "Warning: " << thaw_error << "; container stays paused." << std::endl;
             }
+        }
+        if ((stop_request || signal == SIGKILL || signal == SIGTERM) && wait_for_process_exit(state.pid, KILL_EXIT_TIMEOUT_MS)) {
             state.status = "stopped";
+            log_debug("Container '" + id + "' is stopped.");
+        }
+        if (state.status != previous_status) {
             if (!save_state(state)) {
-                std::cerr << "Failed to persist stopped state for container '" << id << "'" << std::endl;
+                std::cerr << "Failed to persist " << state.status << " state for container '" << id << "'" << std::endl;
             }
             record_state_event(state);
-            log_debug("Container '" + id + "' is stopped.");
         }
     } else {
-        perror("kill failed");
+        log_errno("kill failed");
         record_event(id, "error", json{{"phase", "signal"}, {"message", "kill failed"}});
     }
 }
@@ -2746,7 +4491,7 @@ void delete_container(const std::string& id, bool force) {
 
     if (process_running && force) {
         if (kill(state.pid, SIGKILL) != 0 && errno != ESRCH) {
-            perror("Failed to force terminate container process");
+            log_errno("Failed to force terminate container process");
             return;
         }
         waitpid(state.pid, NULL, 0);
@@ -2791,12 +4536,16 @@ void delete_container(const std::string& id, bool force) {
     std::string events_file = events_file_path(id);
 
     unlink(fifo_file.c_str());
+    if (state.annotations.count("runway.notifySocket")) {
+        unlink(notify_socket_path(id).c_str());
+        rmdir(notify_dir_path(id).c_str());
+    }
     if (remove(state_file.c_str()) != 0) {
-        perror("Failed to delete state file");
+        log_errno("Failed to delete state file");
     }
     unlink(events_file.c_str());
     if (rmdir(container_path.c_str()) != 0) {
-        perror("Failed to delete state directory");
+        log_errno("Failed to delete state directory");
     }
 
     std::string cgroup_path_hint;
@@ -2810,6 +4559,56 @@ void delete_container(const std::string& id, bool force) {
     log_debug("Container '" + id + "' deleted.");
 }
 
+// Chaos testing: RUNWAY_FAULT=<command>:<mode>[,<command>:<mode>...] where
+// command may be "*". Only honored in builds with -DRUNWAY_FAULT_INJECTION.
+bool parse_fault_spec(const std::string& spec, const std::string& command, std::string& mode) {
+    std::istringstream iss(spec);
+    std::string entry;
+    while (std::getline(iss, entry, ',')) {
+        auto sep = entry.find(':');
+        if (sep == std::string::npos) {
+            continue;
+        }
+        std::string target = entry.substr(0, sep);
+        if (target == command || target == "*") {
+            mode = entry.substr(sep + 1);
+            return true;
+        }
+    }
+    return false;
+}
+
+// Returns true when the command must not run; exit_status is then the
+// process exit code. Modes: fail, timeout (hang), corrupt, delay=<ms>.
+bool run_injected_fault(const std::string& command, const std::string& mode, int& exit_status) {
+    log_message("warn", "injecting fault '" + mode + "' into " + command);
+    if (mode == "fail") {
+        std::cerr << "Error: injected failure for " << command << std::endl;
+        exit_status = 1;
+        return true;
+    }
+    if (mode == "timeout") {
+        while (true) {
+            pause();
+        }
+    }
+    if (mode == "corrupt") {
+        std::cout << "{\"ociVersion\": \"" << RUNTIME_VERSION << "\", \"id\": " << std::flush;
+        exit_status = 0;
+        return true;
+    }
+    if (mode.rfind("delay=", 0) == 0) {
+        try {
+            std::this_thread::sleep_for(std::chrono::milliseconds(std::stoi(mode.substr(6))));
+        } catch (const std::exception&) {
+            std::cerr << "Warning: Invalid fault delay '" << mode << "'" << std::endl;
+        }
+        return false;
+    }
+    std::cerr << "Warning: Unknown fault mode '" << mode << "'; ignoring." << std::endl;
+    return false;
+}
+
 void print_usage(const char* prog) {
     std::cerr << "Usage: " << prog << " [global options] <command> [arguments]\n"
               << "\n"
@@ -2819,6 +4618,8 @@ void print_usage(const char* prog) {
               << "  --log-format <fmt>      Log format (text|json)\n"
               << "  --root <path>           Path to the runtime state directory\n"
               << "  --systemd-cgroup        Accept systemd cgroup requests (not yet implemented)\n"
+              << "  --strict                Reject spec fields this runtime does not enforce\n"
+              << "  --private-cgroupns      Give every created container its own cgroup namespace\n"
               << "  --help                  Show this help message\n"
               << "  --version               Show version information\n"
               << "\n"
@@ -2827,18 +4628,22 @@ void print_usage(const char* prog) {
               << "  run [options] <id>      Create, start, and wait on a container\n"
               << "  start  [--attach] <id>  Start a created container\n"
               << "  state  <id>             Show the state of a container\n"
+              << "  features                Print the OCI features supported by this runtime\n"
               << "  exec  [options] <id>    Execute a process inside a running container\n"
               << "  pause <id>              Pause all processes in a running container\n"
               << "  resume <id>             Resume a paused container\n"
               << "  ps    <id>              List processes inside a container\n"
               << "  events [options] <id>   Stream container events or stats\n"
-              << "  kill   <id> [signal]    Send a signal to a container (default: SIGTERM)\n"
+              << "  kill [--all] <id> [signal]\n"
+              << "                          Send a signal to a container (default: runway.stop-signal or SIGTERM)\n"
+              << "  update [options] <id>   Update resources of a created or running container\n"
               << "  delete [--force] <id>   Delete a stopped container\n"
               << "\n"
               << "create options:\n"
               << "  --bundle <path>         Set the OCI bundle directory (default: current directory)\n"
               << "  --pid-file <path>       Write the container init PID to the file\n"
               << "  --console-socket <path> Accepted for compatibility but ignored\n"
+              << "  --notify-socket <path>  Forward sd_notify READY=1 from the container to this socket\n"
               << "\n"
               << "exec options:\n"
               << "  --process <path>        Read process spec (process.json format)\n"
@@ -2847,10 +4652,23 @@ void print_usage(const char* prog) {
               << "  --tty                   Accepted for compatibility but ignored\n"
               << "  --preserve-fds <n>      Accepted for compatibility but ignored\n"
               << "\n"
+              << "kill options:\n"
+              << "  --all                   Signal every process in the container, not just init\n"
+              << "\n"
               << "events options:\n"
               << "  --follow                Stream events until container exit\n"
               << "  --stats                 Emit periodic stats instead of event log\n"
               << "  --interval <ms>         Poll interval for --follow/--stats (default: 1000)\n"
+              << "\n"
+              << "update options:\n"
+              << "  --oom-score-adj <n>     Set oom_score_adj (-1000..1000) for all container processes\n"
+              << "  --memory <bytes>        Set the hard memory limit\n"
+              << "  --memory-reservation <bytes|max>\n"
+              << "                          Set memory.low (v1: soft limit); alias --memory-low\n"
+              << "  --memory-min <bytes|max>\n"
+              << "                          Set memory.min (cgroup v2 only)\n"
+              << "  --memory-high <bytes|max>\n"
+              << "                          Set memory.high throttling threshold (cgroup v2 only)\n"
               << "Run accepts the same options as create.\n"
               << std::endl;
 }
@@ -2868,6 +4686,8 @@ int main(int argc, char* argv[]) {
             {"version", no_argument, nullptr, OPT_VERSION},
             {"help", no_argument, nullptr, OPT_HELP},
             {"systemd-cgroup", no_argument, nullptr, OPT_SYSTEMD_CGROUP},
+            {"strict", no_argument, nullptr, OPT_STRICT},
+            {"private-cgroupns", no_argument, nullptr, OPT_PRIVATE_CGROUPNS},
             {nullptr, 0, nullptr, 0}
     };
 
@@ -2909,6 +4729,12 @@ int main(int argc, char* argv[]) {
             case OPT_SYSTEMD_CGROUP:
                 g_global_options.systemd_cgroup = true;
                 break;
+            case OPT_STRICT:
+                g_global_options.strict = true;
+                break;
+            case OPT_PRIVATE_CGROUPNS:
+                g_global_options.private_cgroupns = true;
+                break;
             case '?': {
                 int idx = std::max(0, optind - 1);
                 std::cerr << "Unknown global option: " << argv[idx] << std::endl;
@@ -2921,6 +4747,10 @@ int main(int argc, char* argv[]) {
         }
     }
 
+    if (g_global_options.log_format == "json") {
+        enable_json_log_lines();
+    }
+
     if (optind >= argc) {
         print_usage(argv[0]);
         return 1;
@@ -2929,6 +4759,18 @@ int main(int argc, char* argv[]) {
     char** command_argv = argv + optind;
     int command_argc = argc - optind;
     std::string command = command_argv[0];
+    g_log_operation = command;
+
+#ifdef RUNWAY_FAULT_INJECTION
+    const char* fault_spec = getenv("RUNWAY_FAULT");
+    std::string fault_mode;
+    if (fault_spec != nullptr && parse_fault_spec(fault_spec, command, fault_mode)) {
+        int fault_status = 0;
+        if (run_injected_fault(command, fault_mode, fault_status)) {
+            return fault_status;
+        }
+    }
+#endif
 
     if (!ensure_runtime_root_directory()) {
         return 1;
@@ -2939,6 +4781,7 @@ int main(int argc, char* argv[]) {
         if (!parse_create_options(command_argc, command_argv, create_opts)) {
             return 1;
         }
+        g_log_container_id = create_opts.id;
         create_container(create_opts);
     } else if (command == "run") {
         return run_container_command(command_argc, command_argv);
@@ -2966,24 +4809,34 @@ int main(int argc, char* argv[]) {
             std::cerr << "Error: Container id is required." << std::endl;
             return 1;
         }
+        g_log_container_id = id;
         start_container(id, attach);
+    } else if (command == "features") {
+        if (command_argc != 1) {
+            print_usage(argv[0]);
+            return 1;
+        }
+        std::cout << runtime_features().dump(4) << std::endl;
     } else if (command == "state") {
         if (command_argc != 2) {
             print_usage(argv[0]);
             return 1;
         }
+        g_log_container_id = command_argv[1];
         show_state(command_argv[1]);
     } else if (command == "exec") {
         ExecOptions exec_opts;
         if (!parse_exec_options(command_argc, command_argv, exec_opts)) {
             return 1;
         }
+        g_log_container_id = exec_opts.id;
         return exec_container(exec_opts);
     } else if (command == "pause") {
         if (command_argc != 2) {
             print_usage(argv[0]);
             return 1;
         }
+        g_log_container_id = command_argv[1];
         pause_container(command_argv[1]);
         return 0;
     } else if (command == "resume") {
@@ -2991,6 +4844,7 @@ int main(int argc, char* argv[]) {
             print_usage(argv[0]);
             return 1;
         }
+        g_log_container_id = command_argv[1];
         resume_container(command_argv[1]);
         return 0;
     } else if (command == "ps") {
@@ -2998,6 +4852,7 @@ int main(int argc, char* argv[]) {
             print_usage(argv[0]);
             return 1;
         }
+        g_log_container_id = command_argv[1];
         list_container_processes(command_argv[1]);
         return 0;
     } else if (command == "events") {
@@ -3005,23 +4860,41 @@ int main(int argc, char* argv[]) {
         if (!parse_events_options(command_argc, command_argv, events_opts)) {
             return 1;
         }
+        g_log_container_id = events_opts.id;
         events_command(events_opts);
         return 0;
+    } else if (command == "update") {
+        UpdateOptions update_opts;
+        if (!parse_update_options(command_argc, command_argv, update_opts)) {
+            return 1;
+        }
+        g_log_container_id = update_opts.id;
+        return update_container(update_opts) ? 0 : 1;
     } else if (command == "kill") {
-        if (command_argc < 2 || command_argc > 3) {
+        bool all = false;
+        std::vector<std::string> positional;
+        for (int i = 1; i < command_argc; ++i) {
+            std::string arg = command_argv[i];
+            if (arg == "--all" || arg == "-a") {
+                all = true;
+                continue;
+            }
+            positional.push_back(arg);
+        }
+        if (positional.empty() || positional.size() > 2) {
             print_usage(argv[0]);
             return 1;
         }
-        int sig = SIGTERM;
-        if (command_argc == 3) {
-            try {
-                sig = std::stoi(command_argv[2]);
-            } catch (const std::exception&) {
-                std::cerr << "Invalid signal value: " << command_argv[2] << std::endl;
+        int sig = -1;
+        if (positional.size() == 2) {
+            sig = parse_signal(positional[1]);
+            if (sig == -1) {
+                std::cerr << "Invalid signal value: " << positional[1] << std::endl;
                 return 1;
             }
         }
-        kill_container(command_argv[1], sig);
+        g_log_container_id = positional[0];
+        kill_container(positional[0], sig, all);
     } else if (command == "delete") {
         bool force = false;
         std::string id;
@@ -3046,6 +4919,7 @@ int main(int argc, char* argv[]) {
             std::cerr << "Error: Container id is required." << std::endl;
             return 1;
         }
+        g_log_container_id = id;
         delete_container(id, force);
     } else {
         std::cerr << "Error: Unknown command '" << command << "'" << std::endl;
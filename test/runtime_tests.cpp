@@ -122,6 +122,55 @@ void test_parse_events_options(TestContext& ctx) {
     ctx.expect(options.id == "demo", "parse_events_options id", options.id);
 }
 
+void test_parse_update_options(TestContext& ctx) {
+    UpdateOptions options;
+    std::vector<std::string> args = {"runtime", "--oom-score-adj", "-500", "--memory-high", "max",
+                                     "--memory-low", "2048", "demo"};
+    std::vector<char*> argv;
+    argv.reserve(args.size());
+    for (auto& arg : args) {
+        argv.push_back(const_cast<char*>(arg.c_str()));
+    }
+    bool ok = parse_update_options(static_cast<int>(args.size()), argv.data(), options);
+    ctx.expect(ok, "parse_update_options success");
+    ctx.expect(options.has_oom_score_adj && options.oom_score_adj == -500, "parse_update_options oom-score-adj");
+    ctx.expect(options.resources.memory_high == -1, "parse_update_options memory-high");
+    ctx.expect(options.resources.memory_reservation == 2048, "parse_update_options memory-low");
+    ctx.expect(options.id == "demo", "parse_update_options id", options.id);
+
+    UpdateOptions invalid_opts;
+    std::vector<std::string> invalid = {"runtime", "--oom-score-adj", "2000", "demo"};
+    std::vector<char*> invalid_argv;
+    for (auto& arg : invalid) {
+        invalid_argv.push_back(const_cast<char*>(arg.c_str()));
+    }
+    int stderr_copy = dup(fileno(stderr));
+    int devnull = open("/dev/null", O_WRONLY);
+    dup2(devnull, fileno(stderr));
+    close(devnull);
+    bool invalid_ok = parse_update_options(static_cast<int>(invalid.size()), invalid_argv.data(), invalid_opts);
+    fflush(stderr);
+    dup2(stderr_copy, fileno(stderr));
+    close(stderr_copy);
+    ctx.expect(!invalid_ok, "parse_update_options rejects out of range oom-score-adj");
+
+    UpdateOptions suffix_opts;
+    std::vector<std::string> suffix = {"runtime", "--oom-score-adj", "12abc", "demo"};
+    std::vector<char*> suffix_argv;
+    for (auto& arg : suffix) {
+        suffix_argv.push_back(const_cast<char*>(arg.c_str()));
+    }
+    stderr_copy = dup(fileno(stderr));
+    devnull = open("/dev/null", O_WRONLY);
+    dup2(devnull, fileno(stderr));
+    close(devnull);
+    bool suffix_ok = parse_update_options(static_cast<int>(suffix.size()), suffix_argv.data(), suffix_opts);
+    fflush(stderr);
+    dup2(stderr_copy, fileno(stderr));
+    close(stderr_copy);
+    ctx.expect(!suffix_ok, "parse_update_options rejects trailing characters in oom-score-adj");
+}
+
 void test_record_event(TestContext& ctx) {
     const std::string root = test_state_root();
     const std::string container_id = "event-test";
@@ -152,6 +201,295 @@ void test_record_event(TestContext& ctx) {
     cleanup_state_root(root, container_id);
 }
 
+void test_read_cgroup_procs(TestContext& ctx) {
+    const std::string path = "/tmp/runway-test-procs-" + std::to_string(getpid());
+    {
+        std::ofstream ofs(path);
+        ofs << "12\n345\n0\n";
+    }
+    std::vector<pid_t> pids = read_cgroup_procs(path);
+    unlink(path.c_str());
+    ctx.expect(pids.size() == 2, "read_cgroup_procs count", std::to_string(pids.size()));
+    ctx.expect(!pids.empty() && pids.front() == 12, "read_cgroup_procs first pid");
+    ctx.expect(read_cgroup_procs(path).empty(), "read_cgroup_procs missing file");
+
+    ctx.expect(cgroup_relative_path("demo", "") == "my_runtime/demo", "cgroup_relative_path default");
+    ctx.expect(cgroup_relative_path("demo", "/pods/demo/") == "pods/demo", "cgroup_relative_path trims slashes");
+}
+
+void test_unsupported_spec_fields(TestContext& ctx) {
+    json spec = json::parse(R"({
+        "ociVersion": "1.0.2",
+        "root": {"path": "rootfs"},
+        "process": {"args": ["/bin/sh"], "user": {"uid": 0}},
+        "linux": {
+            "namespaces": [{"type": "pid"}, {"type": "net", "path": "/proc/1/ns/net"}],
+            "resources": {"memory": {"limit": 1024}, "rdma": {}},
+            "seccomp": null
+        }
+    })");
+    std::vector<std::string> fields = unsupported_spec_fields(spec);
+    ctx.expect(fields.size() == 2, "unsupported_spec_fields count", join_strings(fields));
+    ctx.expect(std::find(fields.begin(), fields.end(), "process.user") != fields.end(),
+               "unsupported_spec_fields process.user");
+    ctx.expect(std::find(fields.begin(), fields.end(), "linux.resources.rdma") != fields.end(),
+               "unsupported_spec_fields linux.resources.rdma");
+}
+
+void test_memory_resources(TestContext& ctx) {
+    long long value = 0;
+    ctx.expect(parse_memory_value("max", value) && value == -1, "parse_memory_value max");
+    ctx.expect(parse_memory_value("4096", value) && value == 4096, "parse_memory_value bytes");
+    ctx.expect(!parse_memory_value("4k", value), "parse_memory_value rejects suffix");
+    ctx.expect(!parse_memory_value("-5", value), "parse_memory_value rejects negative");
+    ctx.expect(memory_value_string(-1) == "max", "memory_value_string v2 unlimited");
+    ctx.expect(memory_v1_value_string(-1) == "-1", "memory_v1_value_string unlimited");
+    ctx.expect(memory_v1_value_string(1024) == "1024", "memory_v1_value_string bytes");
+
+    LinuxResourcesConfig resources = json::parse(R"({
+        "memory": {"limit": 8192, "reservation": 1024},
+        "unified": {"memory.min": "512", "memory.high": "max"}
+    })").get<LinuxResourcesConfig>();
+    ctx.expect(resources.memory_limit == 8192, "resources memory.limit");
+    ctx.expect(resources.memory_reservation == 1024, "resources memory.reservation");
+    ctx.expect(resources.memory_min == 512, "resources unified memory.min");
+    ctx.expect(resources.memory_high == -1, "resources unified memory.high max");
+    ctx.expect(has_memory_resources(resources), "has_memory_resources");
+    ctx.expect(!has_memory_resources(LinuxResourcesConfig()), "has_memory_resources empty");
+}
+
+void test_update_threshold_alert(TestContext& ctx) {
+    ThresholdAlert alert;
+    alert.threshold = 90;
+    alert.duration = std::chrono::seconds(30);
+    const auto start = std::chrono::steady_clock::now();
+    ctx.expect(!update_threshold_alert(alert, 95, start), "threshold alert waits for duration");
+    ctx.expect(!update_threshold_alert(alert, 95, start + std::chrono::seconds(10)), "threshold alert still pending");
+    ctx.expect(update_threshold_alert(alert, 95, start + std::chrono::seconds(30)), "threshold alert fires when sustained");
+    ctx.expect(!update_threshold_alert(alert, 99, start + std::chrono::seconds(40)), "threshold alert fires once");
+    ctx.expect(!update_threshold_alert(alert, 50, start + std::chrono::seconds(41)), "threshold alert re-arms below");
+    ctx.expect(!update_threshold_alert(alert, 95, start + std::chrono::seconds(42)), "threshold alert restarts duration");
+
+    ThresholdAlert disabled;
+    ctx.expect(!update_threshold_alert(disabled, 100, start), "threshold alert disabled without threshold");
+}
+
+void test_runtime_features(TestContext& ctx) {
+    json features = runtime_features();
+    const json& hooks = features["hooks"];
+    ctx.expect(std::find(hooks.begin(), hooks.end(), "createRuntime") != hooks.end(), "features hooks createRuntime");
+    ctx.expect(hooks.size() == supported_spec_fields()["hooks"].size(), "features hooks match supported fields");
+    const json& namespaces = features["linux"]["namespaces"];
+    ctx.expect(std::find(namespaces.begin(), namespaces.end(), "network") != namespaces.end(), "features namespaces network");
+    ctx.expect(features["linux"]["seccomp"]["enabled"] == false, "features seccomp disabled");
+}
+
+void test_fault_injection(TestContext& ctx) {
+    std::string mode;
+    ctx.expect(parse_fault_spec("start:timeout,state:corrupt", "state", mode) && mode == "corrupt",
+               "parse_fault_spec selects command", mode);
+    ctx.expect(!parse_fault_spec("start:timeout", "delete", mode), "parse_fault_spec ignores other commands");
+    ctx.expect(parse_fault_spec("*:fail", "kill", mode) && mode == "fail", "parse_fault_spec wildcard");
+
+    int status = 0;
+    int stderr_copy = dup(fileno(stderr));
+    int devnull = open("/dev/null", O_WRONLY);
+    dup2(devnull, fileno(stderr));
+    close(devnull);
+    bool stopped = run_injected_fault("start", "fail", status);
+    bool delayed = run_injected_fault("start", "delay=1", status);
+    fflush(stderr);
+    dup2(stderr_copy, fileno(stderr));
+    close(stderr_copy);
+    ctx.expect(stopped && status == 1, "run_injected_fault fail stops command");
+    ctx.expect(!delayed, "run_injected_fault delay continues command");
+}
+
+void test_strict_mode_checks(TestContext& ctx) {
+    json spec = json::parse(R"({"linux": {"resources": {"unified": {"memory.min": "1024", "memory.low": "2048"}}}})");
+    std::vector<std::string> v1_fields = host_unenforced_spec_fields(spec, false);
+    ctx.expect(v1_fields.size() == 1 && v1_fields[0] == "linux.resources.unified.memory.min",
+               "host_unenforced_spec_fields memory.min on v1");
+    ctx.expect(host_unenforced_spec_fields(spec, true).empty(), "host_unenforced_spec_fields none on v2");
+
+    json ns_spec = json::parse(R"({"linux": {"namespaces": [{"type": "pid"}, {"type": "net"}, {"type": "time"}]}})");
+    std::vector<std::string> ns_fields = unsupported_namespace_types(ns_spec);
+    ctx.expect(ns_fields.size() == 1 && ns_fields[0] == "linux.namespaces[2].type=time",
+               "unsupported_namespace_types time", join_strings(ns_fields));
+
+    CreateOptions create_opts;
+    create_opts.console_socket = "/tmp/console.sock";
+    create_opts.preserve_fds = 2;
+    ctx.expect(ignored_create_options(create_opts, false).size() == 2, "ignored_create_options console and fds");
+    ctx.expect(ignored_create_options(create_opts, true).size() == 1, "ignored_create_options console used with terminal");
+
+    ExecOptions exec_opts;
+    ctx.expect(ignored_exec_options(exec_opts).empty(), "ignored_exec_options none");
+    exec_opts.tty = true;
+    ctx.expect(ignored_exec_options(exec_opts).size() == 1, "ignored_exec_options tty");
+}
+
+void test_parse_mount_options_idmap(TestContext& ctx) {
+    ParsedMountOptions idmap = parse_mount_options({"bind", "idmap"});
+    ctx.expect(idmap.idmap && !idmap.idmap_recursive, "parse_mount_options idmap");
+    ctx.expect(idmap.data.empty(), "parse_mount_options idmap not passed as data", idmap.data);
+    ParsedMountOptions ridmap = parse_mount_options({"rbind", "ridmap"});
+    ctx.expect(ridmap.idmap && ridmap.idmap_recursive, "parse_mount_options ridmap");
+    ctx.expect(!parse_mount_options({"bind"}).idmap, "parse_mount_options without idmap");
+}
+
+void test_parse_signal(TestContext& ctx) {
+    ctx.expect(parse_signal("9") == SIGKILL, "parse_signal number");
+    ctx.expect(parse_signal("SIGQUIT") == SIGQUIT, "parse_signal SIG name");
+    ctx.expect(parse_signal("term") == SIGTERM, "parse_signal short lowercase name");
+    ctx.expect(parse_signal("SIGBOGUS") == -1, "parse_signal unknown name");
+    ctx.expect(parse_signal("0") == 0, "parse_signal accepts zero for liveness checks");
+    ctx.expect(parse_signal("-1") == -1, "parse_signal rejects negative");
+
+    ContainerState state;
+    ctx.expect(default_stop_signal(state) == SIGTERM, "default_stop_signal fallback");
+    state.annotations["runway.stop-signal"] = "SIGQUIT";
+    ctx.expect(default_stop_signal(state) == SIGQUIT, "default_stop_signal annotation");
+}
+
+void test_notify_message_is_ready(TestContext& ctx) {
+    ctx.expect(notify_message_is_ready("READY=1"), "notify_message_is_ready single line");
+    ctx.expect(notify_message_is_ready("STATUS=booting\nREADY=1\n"), "notify_message_is_ready multi line");
+    ctx.expect(!notify_message_is_ready("STATUS=READY=1"), "notify_message_is_ready ignores status text");
+}
+
+void test_container_state_details(TestContext& ctx) {
+    ContainerState state;
+    state.id = "details";
+    state.status = "running";
+    state.pid = getpid();
+    state.annotations["runway.cgroupPath"] = "pods/details";
+    json details = container_state_details(state);
+    std::string path = details["cgroup"]["path"].get<std::string>();
+    ctx.expect(path.find("pods/details") != std::string::npos, "container_state_details cgroup path", path);
+    ctx.expect(details["namespaces"].contains("pid"), "container_state_details pid namespace");
+
+    state.status = "stopped";
+    ctx.expect(!container_state_details(state).contains("namespaces"), "container_state_details stopped");
+}
+
+void test_log_message_json(TestContext& ctx) {
+    GlobalOptions saved = g_global_options;
+    g_global_options.log_format = "json";
+    g_log_operation = "create";
+    std::ostringstream captured;
+    std::streambuf* original = std::cerr.rdbuf(captured.rdbuf());
+    log_message("info", "hello");
+    std::cerr.rdbuf(original);
+    g_global_options = saved;
+    g_log_operation.clear();
+
+    json entry = json::parse(captured.str(), nullptr, false);
+    ctx.expect(!entry.is_discarded(), "log_message json parses", captured.str());
+    if (!entry.is_discarded()) {
+        ctx.expect(entry["level"] == "info", "log_message json level");
+        ctx.expect(entry["msg"] == "hello", "log_message json msg");
+        ctx.expect(entry["op"] == "create", "log_message json op");
+    }
+}
+
+void test_json_log_line_buf(TestContext& ctx) {
+    g_log_operation = "create";
+    g_log_container_id = "demo";
+    std::ostringstream captured;
+    {
+        JsonLogLineBuf buffer(captured.rdbuf());
+        std::ostream stream(&buffer);
+        stream << "Warning: " << "first" << std::endl;
+        stream << "Failed to open" << ": " << "No such file" << std::endl;
+    }
+    g_log_operation.clear();
+    g_log_container_id.clear();
+
+    std::istringstream lines(captured.str());
+    std::string line;
+    std::vector<json> records;
+    while (std::getline(lines, line)) {
+        records.push_back(json::parse(line, nullptr, false));
+    }
+    ctx.expect(records.size() == 2, "json log lines one record per line", captured.str());
+    if (records.size() != 2 || records[0].is_discarded() || records[1].is_discarded()) {
+        return;
+    }
+    ctx.expect(records[0]["level"] == "warn" && records[0]["msg"] == "first", "json log lines warning prefix");
+    ctx.expect(records[1]["level"] == "error" && records[1]["msg"] == "Failed to open: No such file",
+               "json log lines default error level");
+    ctx.expect(records[1]["id"] == "demo" && records[1]["op"] == "create", "json log lines id and op");
+}
+
+void test_namespace_clone_flag(TestContext& ctx) {
+    ctx.expect(namespace_clone_flag("network") == CLONE_NEWNET, "namespace_clone_flag network");
+    ctx.expect(namespace_clone_flag("net") == CLONE_NEWNET, "namespace_clone_flag net");
+    ctx.expect(namespace_clone_flag("mount") == CLONE_NEWNS, "namespace_clone_flag mount");
+    ctx.expect(namespace_clone_flag("time") == 0, "namespace_clone_flag unknown");
+}
+
+void test_namespace_helpers(TestContext& ctx) {
+    ctx.expect(same_namespace("/proc/self/ns/user", "/proc/self/ns/user"), "same_namespace self");
+    ctx.expect(!same_namespace("/proc/self/ns/user", "/nonexistent"), "same_namespace missing path");
+    ctx.expect(namespace_pid(getpid()) > 0, "namespace_pid self");
+}
+
+void test_write_cgroup_freeze(TestContext& ctx) {
+    const std::string dir = "/tmp/runway-test-freeze-" + std::to_string(getpid());
+    ensure_directory(dir, 0755);
+    ctx.expect(!write_cgroup_freeze(dir, true), "write_cgroup_freeze missing file");
+    {
+        std::ofstream ofs(dir + "/cgroup.freeze");
+        ofs << "0";
+    }
+    ctx.expect(write_cgroup_freeze(dir, true), "write_cgroup_freeze writes");
+    std::ifstream ifs(dir + "/cgroup.freeze");
+    std::string value;
+    ifs >> value;
+    ctx.expect(value == "1", "write_cgroup_freeze value", value);
+    unlink((dir + "/cgroup.freeze").c_str());
+    rmdir(dir.c_str());
+}
+
+void test_freezer_state_helpers(TestContext& ctx) {
+    std::istringstream frozen_events("populated 1\nfrozen 1\n");
+    bool frozen = false;
+    ctx.expect(parse_cgroup_events_frozen(frozen_events, frozen) && frozen, "parse_cgroup_events_frozen frozen");
+    std::istringstream thawed_events("populated 1\nfrozen 0\n");
+    ctx.expect(parse_cgroup_events_frozen(thawed_events, frozen) && !frozen, "parse_cgroup_events_frozen thawed");
+    std::istringstream missing("populated 0\n");
+    ctx.expect(!parse_cgroup_events_frozen(missing, frozen), "parse_cgroup_events_frozen missing key");
+
+    ctx.expect(process_state(getpid()) == 'R', "process_state self running");
+    ctx.expect(wait_for_processes_stopped({getpid()}, false, 100), "wait_for_processes_stopped running");
+}
+
+void test_read_cgroup_keyed_value(TestContext& ctx) {
+    const std::string path = "/tmp/runway-test-memory-events-" + std::to_string(getpid());
+    {
+        std::ofstream ofs(path);
+        ofs << "low 0\nhigh 4\nmax 2\noom 1\noom_kill 1\n";
+    }
+    unsigned long long value = 0;
+    ctx.expect(read_cgroup_keyed_value(path, "oom_kill", value) && value == 1, "read_cgroup_keyed_value oom_kill");
+    ctx.expect(read_cgroup_keyed_value(path, "high", value) && value == 4, "read_cgroup_keyed_value high");
+    ctx.expect(!read_cgroup_keyed_value(path, "oom_group_kill", value), "read_cgroup_keyed_value missing key");
+    unlink(path.c_str());
+}
+
+void test_parse_psi_avg10(TestContext& ctx) {
+    std::istringstream psi("some avg10=12.50 avg60=3.00 avg300=1.00 total=100\n"
+                           "full avg10=4.25 avg60=1.00 avg300=0.50 total=40\n");
+    double avg10 = 0;
+    ctx.expect(parse_psi_avg10(psi, "some", avg10) && avg10 == 12.5, "parse_psi_avg10 some");
+    psi.clear();
+    psi.seekg(0);
+    ctx.expect(parse_psi_avg10(psi, "full", avg10) && avg10 == 4.25, "parse_psi_avg10 full");
+    std::istringstream empty("");
+    ctx.expect(!parse_psi_avg10(empty, "some", avg10), "parse_psi_avg10 empty");
+}
+
 int main() {
     TestContext ctx;
 
@@ -159,7 +497,27 @@ int main() {
     test_collect_process_tree(ctx);
     test_parse_exec_options(ctx);
     test_parse_events_options(ctx);
+    test_parse_update_options(ctx);
     test_record_event(ctx);
+    test_read_cgroup_procs(ctx);
+    test_unsupported_spec_fields(ctx);
+    test_memory_resources(ctx);
+    test_update_threshold_alert(ctx);
+    test_runtime_features(ctx);
+    test_fault_injection(ctx);
+    test_strict_mode_checks(ctx);
+    test_parse_mount_options_idmap(ctx);
+    test_parse_signal(ctx);
+    test_notify_message_is_ready(ctx);
+    test_container_state_details(ctx);
+    test_log_message_json(ctx);
+    test_json_log_line_buf(ctx);
+    test_namespace_clone_flag(ctx);
+    test_namespace_helpers(ctx);
+    test_write_cgroup_freeze(ctx);
+    test_freezer_state_helpers(ctx);
+    test_read_cgroup_keyed_value(ctx);
+    test_parse_psi_avg10(ctx);
 
     std::cout << "[TEST SUMMARY] Passed: " << ctx.passed << ", Failed: " << ctx.failed << std::endl;
     return ctx.failed == 0 ? 0 : 1;